@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,11 +11,8 @@ import (
 	"strings"
 )
 
-var (
-	linguaRobotApiToken string
-)
-
 const (
+	linguaRobotProviderName  = "lingua-robot"
 	linguaRobotRequestFormat = "https://lingua-robot.p.rapidapi.com/language/v1/entries/en/%s"
 	linguaRobotApiHost       = "lingua-robot.p.rapidapi.com"
 )
@@ -61,35 +59,56 @@ type linguaRobotSense struct {
 	Antonyms   []string `json:"antonyms"`
 }
 
-func getDefinitionFromLinguaRobot(item string) (*linguaRobotResponse, error) {
-	if linguaRobotApiToken == "" {
-		linguaRobotApiToken = os.Getenv("LINGUA_ROBOT_API_TOKEN")
-		if linguaRobotApiToken == "" {
-			log.Fatalf("Environment variable for Lingua Robot is not set")
-			return nil, fmt.Errorf("Empty Lingua Robot API token")
-		}
+type linguaRobotProvider struct {
+	apiToken string
+}
+
+func newLinguaRobotProvider() (dictionaryProvider, error) {
+	apiToken := os.Getenv("LINGUA_ROBOT_API_TOKEN")
+	if apiToken == "" {
+		return nil, errProviderNotConfigured
 	}
 
-	item = strings.ToLower(item)
-	requestUrl := fmt.Sprintf(linguaRobotRequestFormat, url.PathEscape(item))
-	request, _ := http.NewRequest("GET", requestUrl, nil)
-	request.Header.Add("x-rapidapi-host", linguaRobotApiHost)
-	request.Header.Add("x-rapidapi-key", linguaRobotApiToken)
+	return &linguaRobotProvider{apiToken: apiToken}, nil
+}
+
+func (provider *linguaRobotProvider) name() string {
+	return linguaRobotProviderName
+}
 
-	contents, err := processRequest(request)
+func (provider *linguaRobotProvider) fetch(ctx context.Context, item string) ([]byte, error) {
+	return getDefinitionFromLinguaRobot(ctx, provider.apiToken, item)
+}
+
+func (provider *linguaRobotProvider) decode(contents []byte) (*dictionaryResponse, error) {
+	var lrResponse linguaRobotResponse
+	err := json.Unmarshal(contents, &lrResponse)
 	if err != nil {
-		fmt.Printf("Failed getting meanings from Lingua Robot for '%s'", item)
 		return nil, err
 	}
 
-	var response linguaRobotResponse
-	err = json.Unmarshal(contents, &response)
+	response := convertLinguaRobotResponse(&lrResponse)
+	if len(response.entries) == 0 {
+		return nil, errNothingFound
+	}
+
+	return response, nil
+}
+
+func getDefinitionFromLinguaRobot(ctx context.Context, apiToken string, item string) ([]byte, error) {
+	item = strings.ToLower(item)
+	requestUrl := fmt.Sprintf(linguaRobotRequestFormat, url.PathEscape(item))
+	request, _ := http.NewRequest("GET", requestUrl, nil)
+	request.Header.Add("x-rapidapi-host", linguaRobotApiHost)
+	request.Header.Add("x-rapidapi-key", apiToken)
+
+	contents, err := providerClient.do(ctx, request)
 	if err != nil {
-		fmt.Printf("Failed response deserialization from Lingua Robot for '%s'", item)
+		log.Printf("Failed getting meanings from Lingua Robot for '%s'. %s", item, err)
 		return nil, err
 	}
 
-	return &response, nil
+	return contents, nil
 }
 
 func convertLinguaRobotResponse(lrResponse *linguaRobotResponse) *dictionaryResponse {
@@ -98,6 +117,7 @@ func convertLinguaRobotResponse(lrResponse *linguaRobotResponse) *dictionaryResp
 	for _, lrEntry := range lrResponse.Entries {
 		var entry dictionaryEntry
 
+		entry.id = lrEntry.Entry
 		entry.item = lrEntry.Entry
 
 		for _, lrPronunciation := range lrEntry.Pronunciations {
@@ -123,12 +143,15 @@ func convertLinguaRobotResponse(lrResponse *linguaRobotResponse) *dictionaryResp
 			for _, lrSense := range lrLexeme.Senses {
 				var itemData dictionaryItemData
 
-				itemData.Definition = lrSense.Definition
+				itemData.Definition = escapeHTML(lrSense.Definition)
 				itemData.Antonyms = lrSense.Antonyms
 				itemData.Synonyms = lrSense.Synonyms
-				itemData.Examples = lrSense.Examples
 
-				lexeme.definitions = append(lexeme.definitions, itemData)
+				for _, example := range lrSense.Examples {
+					itemData.Examples = append(itemData.Examples, escapeHTML(example))
+				}
+
+				lexeme.senses = append(lexeme.senses, lexemeSense{data: itemData})
 			}
 
 			entry.lexemes = append(entry.lexemes, lexeme)
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	purgeLookupsCommand    = "/purge_lookups"
+	providersStatusCommand = "/providers"
+	metricsCommand         = "/metrics"
+)
+
+var (
+	adminUserIDs = map[int]bool{}
+)
+
+// initAdmins reads comma separated Telegram user IDs allowed to run admin commands
+func initAdmins() {
+	for _, value := range strings.Split(os.Getenv("ADMIN_USER_IDS"), ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		userID, err := strconv.Atoi(value)
+		if err != nil {
+			log.Printf("Ignoring invalid admin user ID '%s'", value)
+			continue
+		}
+
+		adminUserIDs[userID] = true
+	}
+}
+
+func isAdminCommand(text string) bool {
+	command := strings.SplitN(text, " ", 2)[0]
+	return command == purgeLookupsCommand || command == providersStatusCommand || command == metricsCommand
+}
+
+func handleAdminCommand(inMessage *tgbotapi.Message) {
+	if inMessage.From == nil || !adminUserIDs[inMessage.From.ID] {
+		sendSimpleReply(inMessage, "This command is for admins only ... 🙅")
+		return
+	}
+
+	parts := strings.SplitN(inMessage.Text, " ", 2)
+	switch parts[0] {
+	case purgeLookupsCommand:
+		query := ""
+		if len(parts) > 1 {
+			query = parts[1]
+		}
+
+		handlePurgeLookupsCommand(inMessage, query)
+	case providersStatusCommand:
+		handleProvidersStatusCommand(inMessage)
+	case metricsCommand:
+		handleMetricsCommand(inMessage)
+	}
+}
+
+// handlePurgeLookupsCommand drops stored lookups of the query or all of them
+func handlePurgeLookupsCommand(inMessage *tgbotapi.Message, query string) {
+	purged, err := providers.purgeLookups(query)
+	if err != nil {
+		handleErrorWithReply(inMessage, err)
+		return
+	}
+
+	if strings.TrimSpace(query) == "" {
+		sendSimpleReply(inMessage, fmt.Sprintf("Purged all %d stored lookups 🧹", purged))
+	} else {
+		sendSimpleReply(inMessage, fmt.Sprintf("Purged %d stored lookups of '%s' 🧹", purged, query))
+	}
+}
+
+// handleProvidersStatusCommand shows breaker states and daily usage of providers
+func handleProvidersStatusCommand(inMessage *tgbotapi.Message) {
+	var sb strings.Builder
+
+	for _, status := range providers.statuses() {
+		limit := "unlimited"
+		if status.dailyLimit > 0 {
+			limit = strconv.Itoa(status.dailyLimit)
+		}
+
+		sb.WriteString(fmt.Sprintf("%s: %s, %d/%s calls today", status.provider, status.state, status.calls, limit))
+		if status.failures > 0 {
+			sb.WriteString(fmt.Sprintf(", %d failures in a row", status.failures))
+		}
+
+		if status.state != breakerClosed {
+			sb.WriteString(fmt.Sprintf("\n  until %s: %s", status.openUntil.UTC().Format(time.RFC3339), status.reason))
+		}
+
+		sb.WriteString("\n")
+	}
+
+	sendSimpleReply(inMessage, sb.String())
+}
+
+// handleMetricsCommand shows counters of the bot published by expvar
+func handleMetricsCommand(inMessage *tgbotapi.Message) {
+	var sb strings.Builder
+
+	for _, name := range botMetrics {
+		if metric := expvar.Get(name); metric != nil {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", name, metric))
+		}
+	}
+
+	sendSimpleReply(inMessage, sb.String())
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"container/list"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// cache is a concurrency-safe key-value store bounded both by entry lifetime
+// and by entry count, the least recently used entries are evicted first
+type cache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	items      map[string]*list.Element
+	// Front is the most recently used entry
+	order *list.List
+	stats cacheStats
+}
+
+type cacheItem struct {
+	key       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+type cacheStats struct {
+	Entries     int   `json:"entries"`
+	Hits        int64 `json:"hits"`
+	Misses      int64 `json:"misses"`
+	Evictions   int64 `json:"evictions"`
+	Expirations int64 `json:"expirations"`
+}
+
+// newCache makes a cache and publishes its statistics under the name. Zero
+// maxEntries means the cache is bounded by entry lifetime only
+func newCache(name string, maxEntries int, ttl time.Duration) *cache {
+	c := &cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		items:      map[string]*list.Element{},
+		order:      list.New(),
+	}
+
+	cacheStatistics.Set(name, expvar.Func(func() interface{} {
+		return c.statistics()
+	}))
+
+	return c
+}
+
+func (c *cache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	item := element.Value.(*cacheItem)
+	if time.Now().After(item.expiresAt) {
+		c.removeElement(element)
+		c.stats.Expirations++
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.order.MoveToFront(element)
+	c.stats.Hits++
+	return item.value, true
+}
+
+func (c *cache) set(key string, value interface{}) {
+	c.setWithTTL(key, value, c.ttl)
+}
+
+func (c *cache) setWithTTL(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.setLocked(key, value, ttl)
+}
+
+func (c *cache) setLocked(key string, value interface{}, ttl time.Duration) {
+	expiresAt := time.Now().Add(ttl)
+	if element, ok := c.items[key]; ok {
+		item := element.Value.(*cacheItem)
+		item.value = value
+		item.expiresAt = expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheItem{
+		key:       key,
+		value:     value,
+		expiresAt: expiresAt,
+	})
+
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// add sets the value unless the key already has one, which lets concurrent
+// callers agree on a single owner of the key
+func (c *cache) add(key string, value interface{}) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		if time.Now().Before(element.Value.(*cacheItem).expiresAt) {
+			return false
+		}
+
+		c.removeElement(element)
+		c.stats.Expirations++
+	}
+
+	c.setLocked(key, value, c.ttl)
+	return true
+}
+
+func (c *cache) delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.removeElement(element)
+	}
+}
+
+func (c *cache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = map[string]*list.Element{}
+	c.order.Init()
+}
+
+// purgeExpired drops expired entries, otherwise they are dropped only once
+// requested or evicted
+func (c *cache) purgeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for element := c.order.Back(); element != nil; {
+		previous := element.Prev()
+		if now.After(element.Value.(*cacheItem).expiresAt) {
+			c.removeElement(element)
+			c.stats.Expirations++
+		}
+
+		element = previous
+	}
+}
+
+func (c *cache) statistics() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+func (c *cache) removeElement(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.items, element.Value.(*cacheItem).key)
+}
+
+// purgeCachesPeriodically keeps memory of rarely requested caches bounded
+func purgeCachesPeriodically(interval time.Duration, caches ...*cache) {
+	for range time.Tick(interval) {
+		for _, c := range caches {
+			c.purgeExpired()
+		}
+	}
+}
@@ -8,22 +8,186 @@ import (
 	"time"
 )
 
-func ensureTrainingTableExists(db *sql.DB) error {
-	log.Print("Checking Training table exists")
-
-	createTableStatement := `
-		CREATE TABLE IF NOT EXISTS training
-		( 
-			user_id int NOT NULL,
-			date timestamp NOT NULL,
-			data text
-		)`
-
-	_, err := db.Exec(createTableStatement)
+// incrementProviderUsage accounts a provider call and returns calls made that day
+func incrementProviderUsage(db *sql.DB, provider string, day string) (int, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed accounting call of '%s' provider. %s", provider, err)
+		}
+	}()
+
+	upsertRowStatement := `
+		INSERT INTO provider_usage (provider, day, calls)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (provider, day) DO UPDATE
+		SET calls = provider_usage.calls + 1
+		RETURNING calls`
+
+	var calls int
+	err = db.QueryRow(upsertRowStatement, provider, day).Scan(&calls)
+	if err != nil {
+		return 0, err
+	}
+
+	return calls, nil
+}
+
+func getProviderUsage(db *sql.DB, provider string, day string) (int, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed requesting usage of '%s' provider. %s", provider, err)
+		}
+	}()
+
+	getUsageStatement := `
+		SELECT calls FROM provider_usage
+		WHERE provider = $1 AND day = $2`
+	var calls int
+	err = db.QueryRow(getUsageStatement, provider, day).Scan(&calls)
+	if err == sql.ErrNoRows {
+		err = nil
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	return calls, nil
+}
+
+type storedLookup struct {
+	contents  []byte
+	fetchedAt time.Time
+}
+
+// getStoredLookup returns nil without error if nothing is stored for the query
+func getStoredLookup(db *sql.DB, provider string, query string) (*storedLookup, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed requesting stored '%s' lookup of '%s' provider. %s", query, provider, err)
+		}
+	}()
+
+	getLookupStatement := `
+		SELECT response, fetched_at FROM lookups
+		WHERE provider = $1 AND query = $2`
+	var lookup storedLookup
+	err = db.QueryRow(getLookupStatement, provider, query).Scan(&lookup.contents, &lookup.fetchedAt)
+	if err == sql.ErrNoRows {
+		err = nil
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &lookup, nil
+}
+
+func storeLookup(db *sql.DB, provider string, query string, contents []byte) error {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed storing '%s' lookup of '%s' provider. %s", query, provider, err)
+		}
+	}()
+
+	upsertRowStatement := `
+		INSERT INTO lookups (provider, query, fetched_at, response)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (provider, query) DO UPDATE
+		SET fetched_at = EXCLUDED.fetched_at, response = EXCLUDED.response`
+
+	_, err = db.Exec(upsertRowStatement, provider, query, time.Now(), string(contents))
 	return err
 }
 
-func storeTrainingData(db *sql.DB, userID int, data *trainingData) error {
+func deleteStoredLookup(db *sql.DB, provider string, query string) error {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed deleting stored '%s' lookup of '%s' provider. %s", query, provider, err)
+		}
+	}()
+
+	_, err = db.Exec(`DELETE FROM lookups WHERE provider = $1 AND query = $2`, provider, query)
+	return err
+}
+
+// purgeStoredLookups removes lookups of the query with every provider or all
+// lookups if the query is empty
+func purgeStoredLookups(db *sql.DB, query string) (int64, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed purging stored lookups of '%s'. %s", query, err)
+		}
+	}()
+
+	var result sql.Result
+	if query == "" {
+		result, err = db.Exec(`DELETE FROM lookups`)
+	} else {
+		result, err = db.Exec(`DELETE FROM lookups WHERE query = $1`, query)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// insertCard stores a new card along with its user and entry. Returns false if
+// the user already has a card of the entry
+func insertCard(tx *sql.Tx, userID int64, card *trainingCard) (bool, error) {
+	_, err := tx.Exec(`INSERT INTO users (id) VALUES ($1) ON CONFLICT DO NOTHING`, userID)
+	if err != nil {
+		return false, err
+	}
+
+	itemData, err := json.Marshal(card.data.ItemData)
+	if err != nil {
+		return false, err
+	}
+
+	// Updating the conflicting row makes it returned
+	upsertEntryStatement := `
+		INSERT INTO entries (item, data)
+		VALUES ($1, $2::jsonb)
+		ON CONFLICT (item, md5(data::text)) DO UPDATE
+		SET item = EXCLUDED.item
+		RETURNING id`
+	var entryID int64
+	err = tx.QueryRow(upsertEntryStatement, card.data.Item, string(itemData)).Scan(&entryID)
+	if err != nil {
+		return false, err
+	}
+
+	state, err := json.Marshal(card.data.trainingSchedule)
+	if err != nil {
+		return false, err
+	}
+
+	insertCardStatement := `
+		INSERT INTO cards (user_id, entry_id, due, scheduler, state)
+		VALUES ($1, $2, $3, $4, $5::jsonb)
+		ON CONFLICT (user_id, entry_id) DO NOTHING
+		RETURNING id`
+	err = tx.QueryRow(insertCardStatement, userID, entryID, card.due, card.data.Scheduler, string(state)).Scan(&card.id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// storeTrainingData starts a card of the data. Storing the same data again
+// keeps the card which is already there and returns false
+func storeTrainingData(db *sql.DB, userID int, data *trainingData) (bool, error) {
 	var err error
 	defer func() {
 		if err != nil {
@@ -31,23 +195,24 @@ func storeTrainingData(db *sql.DB, userID int, data *trainingData) error {
 		}
 	}()
 
-	insertRowStatement := `
-			INSERT INTO training (user_id, date, data)
-			VALUES ($1, $2, $3)`
+	card := trainingCard{data: *data}
+	card.due = time.Now().AddDate(0, 0, startTrainingData(userID, &card.data))
 
-	var jsonData []byte
-	jsonData, err = json.Marshal(data)
+	var tx *sql.Tx
+	tx, err = db.Begin()
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	date := time.Now().AddDate(0, 0, trainingIterationToDays(data.Iteration))
-	_, err = db.Exec(insertRowStatement, userID, date, jsonData)
+	var inserted bool
+	inserted, err = insertCard(tx, int64(userID), &card)
 	if err != nil {
-		return err
+		_ = tx.Rollback()
+		return false, err
 	}
 
-	return nil
+	err = tx.Commit()
+	return inserted, err
 }
 
 func countUserTrainingData(db *sql.DB, userID int) (int, error) {
@@ -59,7 +224,7 @@ func countUserTrainingData(db *sql.DB, userID int) (int, error) {
 	}()
 
 	getUserDataStatement := `
-		SELECT COUNT(*) FROM training 
+		SELECT COUNT(*) FROM cards
 		WHERE user_id = $1`
 	var count int
 	err = db.QueryRow(getUserDataStatement, userID).Scan(&count)
@@ -70,7 +235,35 @@ func countUserTrainingData(db *sql.DB, userID int) (int, error) {
 	return count, nil
 }
 
-func getUserDataToTrain(db *sql.DB, userID int, count int) ([]trainingData, error) {
+// Columns scanned by scanTrainingCard
+const trainingCardColumns = `cards.id, cards.due, cards.scheduler, cards.state, entries.item, entries.data`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTrainingCard(row rowScanner) (*trainingCard, error) {
+	var card trainingCard
+	var state, itemData []byte
+	err := row.Scan(&card.id, &card.due, &card.data.Scheduler, &state, &card.data.Item, &itemData)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(state, &card.data.trainingSchedule)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(itemData, &card.data.ItemData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &card, nil
+}
+
+func getUserTrainingData(db *sql.DB, userID int, count int) ([]trainingData, error) {
 	var err error
 	defer func() {
 		if err != nil {
@@ -84,46 +277,351 @@ func getUserDataToTrain(db *sql.DB, userID int, count int) ([]trainingData, erro
 	}
 
 	getUserTrainingData := `
-		SELECT data FROM training 
-		WHERE user_id = $1
-		ORDER BY date
+		SELECT ` + trainingCardColumns + ` FROM cards
+		JOIN entries ON entries.id = cards.entry_id
+		WHERE cards.user_id = $1
+		ORDER BY cards.due
 		LIMIT $2`
 	var rows *sql.Rows
 	rows, err = db.Query(getUserTrainingData, userID, count)
-	if err != nil && err != sql.ErrNoRows {
+	if err != nil {
 		return nil, err
 	}
 
 	defer rows.Close()
 	var userDataToTrain []trainingData
+	for rows.Next() {
+		card, scanErr := scanTrainingCard(rows)
+		if scanErr != nil {
+			log.Printf("Error while acquiring user training data from row. %q", scanErr)
+			continue
+		}
+
+		userDataToTrain = append(userDataToTrain, card.data)
+	}
+
+	err = rows.Err()
+	return userDataToTrain, err
+}
+
+func countDueTrainingCards(db *sql.DB, userID int, now time.Time) (int, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed requesting due training card count for user with ID %d. %s", userID, err)
+		}
+	}()
+
+	countDueCardsStatement := `
+		SELECT COUNT(*) FROM cards
+		WHERE user_id = $1 AND due <= $2 AND NOT retired`
+	var count int
+	err = db.QueryRow(countDueCardsStatement, userID, now).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
 
+// getNextTrainingCard returns the card which has been due for the longest
+// time or nil without error if no card is due
+func getNextTrainingCard(db *sql.DB, userID int, now time.Time) (*trainingCard, error) {
+	getNextCardStatement := `
+		SELECT ` + trainingCardColumns + ` FROM cards
+		JOIN entries ON entries.id = cards.entry_id
+		WHERE cards.user_id = $1 AND cards.due <= $2 AND NOT cards.retired
+		ORDER BY cards.due
+		LIMIT 1`
+
+	return queryTrainingCard(db, userID, getNextCardStatement, userID, now)
+}
+
+// getTrainingCard returns nil without error if the user has no such card
+func getTrainingCard(db *sql.DB, userID int, cardID int64) (*trainingCard, error) {
+	getCardStatement := `
+		SELECT ` + trainingCardColumns + ` FROM cards
+		JOIN entries ON entries.id = cards.entry_id
+		WHERE cards.user_id = $1 AND cards.id = $2 AND NOT cards.retired`
+
+	return queryTrainingCard(db, userID, getCardStatement, userID, cardID)
+}
+
+func queryTrainingCard(db *sql.DB, userID int, query string, args ...interface{}) (*trainingCard, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed requesting training card for user with ID %d. %s", userID, err)
+		}
+	}()
+
+	var card *trainingCard
+	card, err = scanTrainingCard(db.QueryRow(query, args...))
 	if err == sql.ErrNoRows {
-		log.Printf("Found no data to train for user with ID %d", userID)
 		err = nil
-		return userDataToTrain, nil
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return card, nil
+}
+
+func getNextTrainingDate(db *sql.DB, userID int) (*time.Time, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed requesting next training date for user with ID %d. %s", userID, err)
+		}
+	}()
+
+	getNextDateStatement := `
+		SELECT MIN(due) FROM cards
+		WHERE user_id = $1 AND NOT retired`
+	var date sql.NullTime
+	err = db.QueryRow(getNextDateStatement, userID).Scan(&date)
+	if err != nil || !date.Valid {
+		return nil, err
+	}
+
+	return &date.Time, nil
+}
+
+// rescheduleTrainingCard updates the card only if it is still due, so the same
+// card is never graded twice. Returns false if the card has not been updated
+func rescheduleTrainingCard(db *sql.DB, userID int, card *trainingCard, retired bool, now time.Time) (bool, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed rescheduling training card %d for %d user ID. %s", card.id, userID, err)
+		}
+	}()
+
+	updateRowStatement := `
+		UPDATE cards
+		SET due = $1, state = $2::jsonb, retired = $3
+		WHERE user_id = $4 AND id = $5 AND due <= $6 AND NOT retired`
+
+	var state []byte
+	state, err = json.Marshal(card.data.trainingSchedule)
+	if err != nil {
+		return false, err
+	}
+
+	var result sql.Result
+	result, err = db.Exec(updateRowStatement, card.due, string(state), retired, userID, card.id, now)
+	if err != nil {
+		return false, err
+	}
+
+	var updated int64
+	updated, err = result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return updated > 0, nil
+}
+
+// storeReviewLogEntry logs the review. Interval before the review is the one
+// scheduled by the previous review of the card
+func storeReviewLogEntry(db *sql.DB, userID int, entry *reviewLogEntry) error {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed logging review of training card %d for %d user ID. %s", entry.cardID, userID, err)
+		}
+	}()
+
+	insertRowStatement := `
+		INSERT INTO reviews (user_id, card_id, reviewed_at, grade, time_taken_seconds,
+			due_before, due_after, interval_before, interval_after, state_before, state_after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, (
+			SELECT interval_after FROM reviews
+			WHERE user_id = $1 AND card_id = $2
+			ORDER BY reviewed_at DESC, id DESC
+			LIMIT 1
+		), $8, $9::jsonb, $10::jsonb)`
+
+	var stateBefore, stateAfter []byte
+	stateBefore, err = json.Marshal(entry.before.trainingSchedule)
+	if err != nil {
+		return err
+	}
+
+	stateAfter, err = json.Marshal(entry.after.trainingSchedule)
+	if err != nil {
+		return err
+	}
+
+	var timeTaken interface{}
+	if entry.timeTaken > 0 {
+		timeTaken = int(entry.timeTaken.Seconds())
+	}
+
+	_, err = db.Exec(insertRowStatement, userID, entry.cardID, entry.reviewedAt, int(entry.grade),
+		timeTaken, entry.dueBefore, entry.dueAfter, entry.intervalAfter,
+		string(stateBefore), string(stateAfter))
+	return err
+}
+
+// undoLastReview restores the card to its state before the last review of the
+// user and drops the review from the log. Returns nil without error if there
+// is nothing to undo
+func undoLastReview(db *sql.DB, userID int) (*undoneReview, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed undoing last review for %d user ID. %s", userID, err)
+		}
+	}()
+
+	var tx *sql.Tx
+	tx, err = db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	getLastReviewStatement := `
+		SELECT reviews.id, reviews.card_id, reviews.grade, reviews.due_before, reviews.state_before, entries.item
+		FROM reviews
+		JOIN cards ON cards.id = reviews.card_id
+		JOIN entries ON entries.id = cards.entry_id
+		WHERE reviews.user_id = $1
+		ORDER BY reviews.reviewed_at DESC, reviews.id DESC
+		LIMIT 1
+		FOR UPDATE OF reviews, cards`
+	var reviewID int64
+	var undone undoneReview
+	var dueBefore sql.NullTime
+	var stateBefore []byte
+	err = tx.QueryRow(getLastReviewStatement, userID).Scan(&reviewID, &undone.cardID, &undone.grade, &dueBefore, &stateBefore, &undone.item)
+	if err == sql.ErrNoRows {
+		err = tx.Rollback()
+		return nil, err
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !dueBefore.Valid || stateBefore == nil {
+		err = errReviewNotUndoable
+		return nil, err
 	}
 
+	// Only a card which is not retired can be reviewed, so restored card is never retired
+	updateCardStatement := `
+		UPDATE cards
+		SET due = $1, state = $2, retired = false
+		WHERE user_id = $3 AND id = $4`
+	_, err = tx.Exec(updateCardStatement, dueBefore.Time, string(stateBefore), userID, undone.cardID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM reviews WHERE id = $1`, reviewID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &undone, nil
+}
+
+// getUserReviewOutcomes returns reviews of the user ordered by card and time
+func getUserReviewOutcomes(db *sql.DB, userID int) ([]reviewOutcome, error) {
+	var err error
+	defer func() {
+		if err != nil {
+			log.Printf("Failed requesting review log for user with ID %d. %s", userID, err)
+		}
+	}()
+
+	getReviewsStatement := `
+		SELECT card_id, reviewed_at, grade FROM reviews
+		WHERE user_id = $1
+		ORDER BY card_id, reviewed_at`
+	var rows *sql.Rows
+	rows, err = db.Query(getReviewsStatement, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+	var outcomes []reviewOutcome
 	for rows.Next() {
-		var rawData []byte
-		err = rows.Scan(&rawData)
+		var outcome reviewOutcome
+		err = rows.Scan(&outcome.cardID, &outcome.reviewedAt, &outcome.grade)
+		if err != nil {
+			return nil, err
+		}
+
+		outcomes = append(outcomes, outcome)
+	}
+
+	err = rows.Err()
+	return outcomes, err
+}
 
+// getFSRSWeights returns nil without error if weights are not optimized for the user
+func getFSRSWeights(db *sql.DB, userID int) (fsrsWeights, error) {
+	var err error
+	defer func() {
 		if err != nil {
-			log.Printf("Error while acquiring user training data from row. %q", err)
-			continue
-		} else if len(rawData) == 0 {
-			log.Printf("Empty user training data for %d", userID)
-			continue
+			log.Printf("Failed requesting FSRS weights for user with ID %d. %s", userID, err)
 		}
+	}()
+
+	getWeightsStatement := `
+		SELECT weights FROM fsrs_parameters
+		WHERE user_id = $1`
+	var rawWeights []byte
+	err = db.QueryRow(getWeightsStatement, userID).Scan(&rawWeights)
+	if err == sql.ErrNoRows {
+		err = nil
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var weights fsrsWeights
+	err = json.Unmarshal(rawWeights, &weights)
+	if err != nil {
+		return nil, err
+	}
+
+	return weights, nil
+}
 
-		data := trainingData{}
-		err = json.Unmarshal(rawData, &data)
+func storeFSRSWeights(db *sql.DB, userID int, weights fsrsWeights, reviews int) error {
+	var err error
+	defer func() {
 		if err != nil {
-			log.Printf("Error while deserializing user training data from row. %q", err)
-			continue
+			log.Printf("Failed storing FSRS weights for %d user ID. %s", userID, err)
 		}
+	}()
 
-		userDataToTrain = append(userDataToTrain, data)
+	upsertRowStatement := `
+		INSERT INTO fsrs_parameters (user_id, weights, reviews, optimized_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) DO UPDATE
+		SET weights = EXCLUDED.weights, reviews = EXCLUDED.reviews, optimized_at = EXCLUDED.optimized_at`
+
+	var jsonWeights []byte
+	jsonWeights, err = json.Marshal(weights)
+	if err != nil {
+		return err
 	}
 
-	return userDataToTrain, nil
+	_, err = db.Exec(upsertRowStatement, userID, string(jsonWeights), reviews, time.Now())
+	return err
 }
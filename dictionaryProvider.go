@@ -0,0 +1,521 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// Learner's dictionary is opt-in, falling back to it would spend yet
+	// another MW quota on items collegiate dictionary does not know
+	defaultDictionaryProviders = "merriam-webster,lingua-robot,wiktionary,offline"
+	defaultDictionaryEnrichers = "merriam-webster-thesaurus,wiktionary"
+	maxCachedLookups           = 1000
+	cachedLookupLifeSpan       = 6 * time.Hour
+	cachedNothingFoundLifeSpan = 30 * time.Minute
+	// Stored lookups are served as they are while fresh, then served while
+	// revalidated in the background for as long as they are stale
+	defaultStoredLookupFreshness = 30 * 24 * time.Hour
+	defaultStoredLookupStaleness = 30 * 24 * time.Hour
+	revalidationLifeSpan         = time.Minute
+	lookupTimeout                = 30 * time.Second
+	// Slow provider leaves the rest of the lookup time to the next ones
+	providerLookupTimeout = 15 * time.Second
+)
+
+var (
+	errNothingFound          = errors.New("nothing has been found")
+	errQuotaExceeded         = errors.New("provider quota is exceeded")
+	errProviderNotConfigured = errors.New("provider is not configured")
+)
+
+// dictionaryProvider fetches raw responses and decodes them separately, so raw
+// responses can be stored and decoded again without spending provider quota
+type dictionaryProvider interface {
+	name() string
+	fetch(ctx context.Context, item string) ([]byte, error)
+	decode(contents []byte) (*dictionaryResponse, error)
+}
+
+// localDictionaryProvider reads dictionaries from disk, so its responses are
+// neither stored nor accounted against any quota
+type localDictionaryProvider interface {
+	isLocal() bool
+}
+
+func isLocalProvider(provider dictionaryProvider) bool {
+	local, ok := provider.(localDictionaryProvider)
+	return ok && local.isLocal()
+}
+
+// dictionaryEnricher adds its own response of the same query to responses of
+// other providers (e.g. synonyms to senses of another dictionary)
+type dictionaryEnricher interface {
+	enriches(providerName string) bool
+	enrich(response *dictionaryResponse, enrichment *dictionaryResponse)
+}
+
+type dictionaryProviderFactory func() (dictionaryProvider, error)
+
+// Providers which only enrich responses of others and never answer lookups
+var enrichingOnlyProviders = map[string]bool{
+	mWThesaurusProviderName: true,
+}
+
+var dictionaryProviderFactories = map[string]dictionaryProviderFactory{
+	mWProviderName:          newMWDictionaryProvider,
+	mWLearnersProviderName:  newMWLearnersProvider,
+	mWThesaurusProviderName: newMWThesaurusProvider,
+	linguaRobotProviderName: newLinguaRobotProvider,
+	offlineProviderName:     newOfflineDictionaryProvider,
+	wiktionaryProviderName:  newWiktionaryProvider,
+}
+
+type dictionaryProviderRegistry struct {
+	// Providers asked for lookups in the configured order
+	providers []dictionaryProvider
+	// Providers whose responses are added to the responses of others
+	enrichers []dictionaryProvider
+	// Every configured provider by name
+	byName map[string]dictionaryProvider
+	// Provider responses by provider and normalized item
+	lookups *cache
+	// Stored lookups are not used without database
+	db        *sql.DB
+	freshness time.Duration
+	staleness time.Duration
+	// Lookups being revalidated in the background
+	revalidations *cache
+	breakers      map[string]*providerBreaker
+}
+
+type cachedLookup struct {
+	response *dictionaryResponse
+	err      error
+}
+
+func newDictionaryProviderRegistry(order string, enrichers string, db *sql.DB) (*dictionaryProviderRegistry, error) {
+	log.Print("Setting up dictionary providers")
+
+	registry := dictionaryProviderRegistry{
+		byName:        map[string]dictionaryProvider{},
+		lookups:       newCache("lookups", maxCachedLookups, cachedLookupLifeSpan),
+		db:            db,
+		freshness:     durationFromEnv("LOOKUP_CACHE_FRESHNESS", defaultStoredLookupFreshness),
+		staleness:     durationFromEnv("LOOKUP_CACHE_STALENESS", defaultStoredLookupStaleness),
+		revalidations: newCache("revalidations", 0, revalidationLifeSpan),
+		breakers:      map[string]*providerBreaker{},
+	}
+
+	for _, providerName := range splitProviderNames(order) {
+		if enrichingOnlyProviders[providerName] {
+			log.Printf("Skipping dictionary provider '%s', it only enriches other providers", providerName)
+			continue
+		}
+
+		provider, err := registry.provider(providerName)
+		if err != nil {
+			return nil, err
+		} else if provider != nil {
+			registry.providers = append(registry.providers, provider)
+		}
+	}
+
+	if len(registry.providers) == 0 {
+		return nil, fmt.Errorf("no dictionary provider is available out of '%s'", order)
+	}
+
+	for _, providerName := range splitProviderNames(enrichers) {
+		provider, err := registry.provider(providerName)
+		if err != nil {
+			return nil, err
+		} else if provider == nil {
+			continue
+		}
+
+		if _, ok := provider.(dictionaryEnricher); !ok {
+			log.Printf("Skipping dictionary enricher '%s', it cannot enrich other providers", providerName)
+			continue
+		}
+
+		registry.enrichers = append(registry.enrichers, provider)
+	}
+
+	return &registry, nil
+}
+
+// provider creates the provider once, so a provider which both answers
+// lookups and enriches shares its state. Unavailable provider is nil
+func (registry *dictionaryProviderRegistry) provider(providerName string) (dictionaryProvider, error) {
+	if provider, ok := registry.byName[providerName]; ok {
+		return provider, nil
+	}
+
+	factory, ok := dictionaryProviderFactories[providerName]
+	if !ok {
+		return nil, fmt.Errorf("unknown dictionary provider '%s'", providerName)
+	}
+
+	provider, err := factory()
+	if err != nil {
+		log.Printf("Skipping dictionary provider '%s'. %s", providerName, err)
+		registry.byName[providerName] = nil
+		return nil, nil
+	}
+
+	registry.byName[providerName] = provider
+	registry.breakers[provider.name()] = newProviderBreaker(provider.name())
+	return provider, nil
+}
+
+// configured returns lookup providers followed by the enriching only ones
+func (registry *dictionaryProviderRegistry) configured() []dictionaryProvider {
+	providers := append([]dictionaryProvider(nil), registry.providers...)
+	for _, enricher := range registry.enrichers {
+		found := false
+		for _, provider := range registry.providers {
+			found = found || provider == enricher
+		}
+
+		if !found {
+			providers = append(providers, enricher)
+		}
+	}
+
+	return providers
+}
+
+func splitProviderNames(order string) []string {
+	var names []string
+	for _, providerName := range strings.Split(order, ",") {
+		if providerName = strings.TrimSpace(providerName); providerName != "" {
+			names = append(names, providerName)
+		}
+	}
+
+	return names
+}
+
+func dictionaryProvidersOrder() string {
+	order := os.Getenv("DICTIONARY_PROVIDERS")
+	if order == "" {
+		return defaultDictionaryProviders
+	}
+
+	return order
+}
+
+// dictionaryEnrichersOrder reads DICTIONARY_ENRICHERS, which may be set to
+// "none" to switch enrichment off
+func dictionaryEnrichersOrder() string {
+	enrichers := os.Getenv("DICTIONARY_ENRICHERS")
+	if enrichers == "" {
+		return defaultDictionaryEnrichers
+	} else if enrichers == "none" {
+		return ""
+	}
+
+	return enrichers
+}
+
+func durationFromEnv(name string, defaultDuration time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultDuration
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		log.Printf("Ignoring invalid duration '%s' of %s environment variable", value, name)
+		return defaultDuration
+	}
+
+	return duration
+}
+
+func normalizeLookupQuery(item string) string {
+	return strings.Join(strings.Fields(strings.ToLower(item)), " ")
+}
+
+func lookupCacheKey(providerName string, query string) string {
+	return fmt.Sprintf("%s|%s", providerName, query)
+}
+
+// lookup asks providers in the configured order and falls back to the next one
+// on errors, empty results or exhausted quota. Suggestions are returned only
+// when no provider has entries for the item. Providers are not asked anymore
+// once the context is done
+func (registry *dictionaryProviderRegistry) lookup(ctx context.Context, item string) (*dictionaryResponse, error) {
+	var lastErr error
+	var suggestionsResponse *dictionaryResponse
+	nothingFound := false
+
+	for _, provider := range registry.providers {
+		if ctx.Err() != nil {
+			break
+		}
+
+		providerCtx, cancel := context.WithTimeout(ctx, providerLookupTimeout)
+		response, err := registry.lookupWith(providerCtx, provider, item)
+		cancel()
+
+		if err == nil && response != nil && len(response.entries) > 0 {
+			return response, nil
+		}
+
+		if err == nil && response != nil && len(response.suggestions) > 0 {
+			if suggestionsResponse == nil {
+				suggestionsResponse = response
+			}
+
+			continue
+		}
+
+		if err == nil || errors.Is(err, errNothingFound) {
+			nothingFound = true
+			continue
+		}
+
+		if !errors.Is(err, errProviderUnavailable) {
+			log.Printf("Dictionary provider '%s' failed for '%s'. %s", provider.name(), item, err)
+		}
+
+		lastErr = err
+	}
+
+	if suggestionsResponse != nil {
+		return suggestionsResponse, nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if nothingFound || lastErr == nil {
+		return nil, errNothingFound
+	}
+
+	return nil, lastErr
+}
+
+// lookupEntry finds an entry by its ID with the provider it came from. Entry
+// IDs are headwords optionally followed by ":" and a homograph number
+func (registry *dictionaryProviderRegistry) lookupEntry(ctx context.Context, providerName string, entryID string) (*dictionaryEntry, error) {
+	provider := registry.byName[providerName]
+	if provider == nil {
+		return nil, fmt.Errorf("dictionary provider '%s' is not available", providerName)
+	}
+
+	item := entryIDItem(entryID)
+	response, err := registry.lookupWith(ctx, provider, item)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range response.entries {
+		if response.entries[idx].id == entryID {
+			return &response.entries[idx], nil
+		}
+	}
+
+	return nil, fmt.Errorf("entry '%s' is not found with '%s' provider", entryID, providerName)
+}
+
+// lookupWith asks the provider unless its response is cached or stored. Failures
+// other than missing items are not cached, so they are retried with the next request
+func (registry *dictionaryProviderRegistry) lookupWith(ctx context.Context, provider dictionaryProvider, item string) (*dictionaryResponse, error) {
+	query := normalizeLookupQuery(item)
+	key := lookupCacheKey(provider.name(), query)
+	if cached, ok := registry.lookups.get(key); ok {
+		lookup := cached.(cachedLookup)
+		return lookup.response, lookup.err
+	}
+
+	response, err := registry.lookupStored(ctx, provider, query)
+	if response != nil {
+		response.provider = provider.name()
+		registry.enrich(ctx, provider, query, response)
+	}
+
+	registry.cacheLookup(key, response, err)
+	return response, err
+}
+
+// enrich adds responses of enriching providers to a fresh response before it
+// is cached. Enrichment is optional, so its failures are ignored
+func (registry *dictionaryProviderRegistry) enrich(ctx context.Context, provider dictionaryProvider, query string, response *dictionaryResponse) {
+	if len(response.entries) == 0 {
+		return
+	}
+
+	for _, enrichingProvider := range registry.enrichers {
+		enricher := enrichingProvider.(dictionaryEnricher)
+		if enrichingProvider == provider || !enricher.enriches(provider.name()) {
+			continue
+		}
+
+		enrichment, err := registry.lookupWith(ctx, enrichingProvider, query)
+		if err != nil || enrichment == nil {
+			continue
+		}
+
+		enricher.enrich(response, enrichment)
+	}
+}
+
+func (registry *dictionaryProviderRegistry) cacheLookup(key string, response *dictionaryResponse, err error) {
+	if err == nil && response != nil {
+		registry.lookups.set(key, cachedLookup{response: response})
+	} else if err == nil || errors.Is(err, errNothingFound) {
+		registry.lookups.setWithTTL(key, cachedLookup{response: response, err: err}, cachedNothingFoundLifeSpan)
+	}
+}
+
+// lookupStored serves the stored response while it is fresh or stale and asks
+// the provider otherwise. Expired response is still better than no response
+// when the provider fails
+func (registry *dictionaryProviderRegistry) lookupStored(ctx context.Context, provider dictionaryProvider, query string) (*dictionaryResponse, error) {
+	if registry.db == nil || isLocalProvider(provider) {
+		return registry.fetch(ctx, provider, query)
+	}
+
+	stored, err := getStoredLookup(registry.db, provider.name(), query)
+	if err != nil || stored == nil {
+		return registry.fetch(ctx, provider, query)
+	}
+
+	// Response stored in a format the provider no longer decodes is useless
+	storedResponse, storedErr := provider.decode(stored.contents)
+	if storedErr != nil && !errors.Is(storedErr, errNothingFound) {
+		log.Printf("Failed decoding stored '%s' lookup of '%s' provider. %s", query, provider.name(), storedErr)
+		// Failing to delete is not a reason to fail the lookup, fetched response replaces it anyway
+		_ = deleteStoredLookup(registry.db, provider.name(), query)
+		return registry.fetch(ctx, provider, query)
+	}
+
+	age := time.Since(stored.fetchedAt)
+	if age > registry.freshness+registry.staleness {
+		response, err := registry.fetch(ctx, provider, query)
+		if err == nil || errors.Is(err, errNothingFound) {
+			return response, err
+		}
+
+		log.Printf("Serving expired '%s' lookup of '%s' provider. %s", query, provider.name(), err)
+	} else if age > registry.freshness {
+		registry.revalidate(provider, query)
+	}
+
+	return storedResponse, storedErr
+}
+
+func (registry *dictionaryProviderRegistry) revalidate(provider dictionaryProvider, query string) {
+	key := lookupCacheKey(provider.name(), query)
+	if !registry.revalidations.add(key, true) {
+		return
+	}
+
+	go func() {
+		defer registry.revalidations.delete(key)
+
+		// Revalidation outlives the request which triggered it
+		ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+		defer cancel()
+
+		response, err := registry.fetch(ctx, provider, query)
+		if err != nil && !errors.Is(err, errNothingFound) {
+			log.Printf("Failed revalidating '%s' lookup of '%s' provider. %s", query, provider.name(), err)
+			return
+		}
+
+		if response != nil {
+			response.provider = provider.name()
+			registry.enrich(ctx, provider, query, response)
+		}
+
+		registry.cacheLookup(key, response, err)
+	}()
+}
+
+// fetch asks the provider unless its circuit breaker is open and stores the
+// response if it can be decoded. Every request sent to the provider, retries
+// included, is accounted against the quota
+func (registry *dictionaryProviderRegistry) fetch(ctx context.Context, provider dictionaryProvider, query string) (*dictionaryResponse, error) {
+	breaker := registry.breakers[provider.name()]
+	if !breaker.allow() {
+		return nil, errProviderUnavailable
+	}
+
+	isLocal := isLocalProvider(provider)
+	if !isLocal {
+		ctx = withProviderCallRecorder(ctx, func() {
+			storedCalls := 0
+			if registry.db != nil {
+				// Failing to account is not a reason to fail the lookup
+				storedCalls, _ = incrementProviderUsage(registry.db, provider.name(), usageDay(time.Now()))
+			}
+
+			breaker.recordCall(storedCalls)
+		})
+	}
+
+	contents, err := provider.fetch(ctx, query)
+	breaker.recordResult(err)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := provider.decode(contents)
+	if err != nil && !errors.Is(err, errNothingFound) {
+		return nil, err
+	}
+
+	if registry.db != nil && !isLocal {
+		// Failing to store is not a reason to fail the lookup
+		_ = storeLookup(registry.db, provider.name(), query, contents)
+	}
+
+	return response, err
+}
+
+// purgeLookups drops stored and cached lookups of the query or all of them if
+// the query is empty
+func (registry *dictionaryProviderRegistry) purgeLookups(item string) (int64, error) {
+	query := normalizeLookupQuery(item)
+
+	if query == "" {
+		registry.lookups.clear()
+	} else {
+		for _, provider := range registry.configured() {
+			registry.lookups.delete(lookupCacheKey(provider.name(), query))
+		}
+	}
+
+	if registry.db == nil {
+		return 0, nil
+	}
+
+	return purgeStoredLookups(registry.db, query)
+}
+
+// statuses tells the state of every provider in the configured order
+func (registry *dictionaryProviderRegistry) statuses() []providerBreakerStatus {
+	var statuses []providerBreakerStatus
+	for _, provider := range registry.configured() {
+		status := registry.breakers[provider.name()].status()
+		if registry.db != nil {
+			if calls, err := getProviderUsage(registry.db, provider.name(), usageDay(time.Now())); err == nil {
+				status.calls = calls
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	maxSaveLabelDefinitionLength = 40
+)
+
+// renderDictionaryResponse turns the response into Telegram HTML. Definitions,
+// examples and section texts are expected to be HTML already, all the other
+// texts are escaped here
+func renderDictionaryResponse(response *dictionaryResponse) *responseContent {
+	builder := newResponseBuilder()
+
+	isFirst := true
+	for _, entry := range response.entries {
+		pronunciations := formatPronunciations(entry.pronunciations)
+		senseIndex := -1
+
+		for _, lexeme := range entry.lexemes {
+			if isFirst {
+				isFirst = false
+			} else if !lexeme.runOn {
+				builder.append("\n")
+			}
+
+			lemma := lexeme.lemma
+			if lemma == "" {
+				lemma = entry.item
+			}
+
+			marker := "🔲"
+			if lexeme.runOn {
+				marker = "🔸"
+			}
+
+			var header strings.Builder
+			header.WriteString(fmt.Sprintf("%s <code>%s</code>", marker, escapeHTML(lemma)))
+			if lexeme.partOfSpeech != "" {
+				header.WriteString(fmt.Sprintf(" <i>%s</i>", escapeHTML(lexeme.partOfSpeech)))
+			}
+
+			if lexeme.grammaticalNote != "" {
+				header.WriteString(fmt.Sprintf(" [%s]", escapeHTML(lexeme.grammaticalNote)))
+			}
+
+			if len(lexeme.labels) > 0 {
+				header.WriteString(fmt.Sprintf(" <i>%s</i>", escapeHTML(strings.Join(lexeme.labels, ", "))))
+			}
+
+			header.WriteString("\n")
+			builder.append(header.String())
+
+			lexemePronunciations := pronunciations
+			if lexeme.runOn {
+				lexemePronunciations = formatPronunciations(lexeme.pronunciations)
+			}
+
+			if lexemePronunciations != "" {
+				builder.append(lexemePronunciations)
+				builder.append("\n")
+			}
+
+			inflections := formatInflections(lexeme.inflections)
+			if inflections != "" {
+				builder.append(inflections)
+				builder.append("\n")
+			}
+
+			for _, crossReference := range lexeme.crossReferences {
+				builder.append(fmt.Sprintf("<i>%s</i>", escapeHTML(crossReference.label)))
+				for idx, target := range crossReference.targets {
+					separator := " "
+					if idx > 0 {
+						separator = ", "
+					}
+
+					builder.appendWithLookup(fmt.Sprintf("%s<code>%s</code>", separator, escapeHTML(target.word)), target.word)
+					if target.senseNumber != "" {
+						builder.append(fmt.Sprintf(" <i>sense</i> %s", escapeHTML(target.senseNumber)))
+					}
+				}
+
+				builder.append("\n")
+			}
+
+			for _, translation := range lexeme.translations {
+				builder.append(formatTranslation(translation))
+				builder.append("\n")
+			}
+
+			for _, sense := range lexeme.senses {
+				// Senses are addressed by their position within the entry
+				senseIndex++
+
+				sectionHeader := formatSectionHeader(sense)
+				if sectionHeader != "" {
+					builder.append(sectionHeader)
+					builder.append("\n")
+				}
+
+				token := saveToken{
+					provider:   response.provider,
+					entryID:    entry.id,
+					senseIndex: senseIndex,
+				}
+
+				query, err := token.encode()
+				if sense.data.Definition == "" || entry.id == "" || err != nil {
+					builder.append(formatSense(sense))
+				} else {
+					builder.appendWithQuery(formatSense(sense), query, formatSaveLabel(lemma, sense.data.Definition))
+				}
+
+				builder.append("\n")
+			}
+		}
+
+		for _, section := range entry.sections {
+			builder.append(formatSection(section))
+			builder.append("\n")
+		}
+	}
+
+	return builder.finish()
+}
+
+// formatSaveLabel makes a short plain text label telling which definition is saved
+func formatSaveLabel(lemma string, definition string) string {
+	label := fmt.Sprintf("💾 %s", lemma)
+
+	definition = strings.TrimLeft(stripHTML(definition), ": ")
+	if definition == "" {
+		return label
+	}
+
+	if runes := []rune(definition); len(runes) > maxSaveLabelDefinitionLength {
+		definition = string(runes[:maxSaveLabelDefinitionLength]) + "…"
+	}
+
+	return fmt.Sprintf("%s: %s", label, definition)
+}
+
+func formatSense(sense lexemeSense) string {
+	var sb strings.Builder
+
+	switch {
+	case sense.kind == senseKindGeneral:
+		sb.WriteString("◽️")
+	case sense.kind == senseKindSubsense && sense.number != "":
+		sb.WriteString(fmt.Sprintf("▪(%s) ", sense.number))
+	case sense.kind == senseKindDivided:
+		sb.WriteString(fmt.Sprintf("<i>%s</i>", escapeHTML(sense.label)))
+	default:
+		sb.WriteString("▪️")
+	}
+
+	var prefixes []string
+	if sense.grammaticalNote != "" {
+		prefixes = append(prefixes, fmt.Sprintf("[%s]", escapeHTML(sense.grammaticalNote)))
+	}
+
+	if len(sense.labels) > 0 {
+		prefixes = append(prefixes, fmt.Sprintf("<i>%s</i>", escapeHTML(strings.Join(sense.labels, ", "))))
+	}
+
+	if sense.data.Definition != "" {
+		prefixes = append(prefixes, sense.data.Definition)
+	}
+
+	sb.WriteString(strings.Join(prefixes, " "))
+
+	for _, example := range sense.data.Examples {
+		if example != "" {
+			sb.WriteString(fmt.Sprintf("\n// %s", example))
+		}
+	}
+
+	if len(sense.data.Synonyms) > 0 {
+		sb.WriteString(fmt.Sprintf("\n<i>synonyms:</i> %s", escapeHTML(strings.Join(sense.data.Synonyms, ", "))))
+	}
+
+	if len(sense.data.Antonyms) > 0 {
+		sb.WriteString(fmt.Sprintf("\n<i>antonyms:</i> %s", escapeHTML(strings.Join(sense.data.Antonyms, ", "))))
+	}
+
+	for _, translation := range sense.data.Translations {
+		sb.WriteString("\n")
+		sb.WriteString(formatTranslation(translation))
+	}
+
+	return sb.String()
+}
+
+func formatTranslation(translation string) string {
+	return fmt.Sprintf("🌐 %s", escapeHTML(translation))
+}
+
+func formatSectionHeader(sense lexemeSense) string {
+	var parts []string
+
+	if sense.divider != "" {
+		parts = append(parts, fmt.Sprintf("[<i>%s</i>]", escapeHTML(sense.divider)))
+	}
+
+	if len(sense.dividerLabels) > 0 {
+		parts = append(parts, fmt.Sprintf("<i>%s</i>", escapeHTML(strings.Join(sense.dividerLabels, ", "))))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+func formatInflections(inflections []lexemeInflection) string {
+	var parts []string
+
+	for _, inflection := range inflections {
+		switch {
+		case inflection.label != "" && inflection.form != "":
+			parts = append(parts, fmt.Sprintf("<i>%s</i> <b>%s</b>", escapeHTML(inflection.label), escapeHTML(inflection.form)))
+		case inflection.label != "":
+			parts = append(parts, fmt.Sprintf("<i>%s</i>", escapeHTML(inflection.label)))
+		default:
+			parts = append(parts, fmt.Sprintf("<b>%s</b>", escapeHTML(inflection.form)))
+		}
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+func formatSection(section entrySection) string {
+	var sb strings.Builder
+
+	sb.WriteString(fmt.Sprintf("🔹 <b>%s</b>", escapeHTML(section.title)))
+	if section.text != "" {
+		sb.WriteString(fmt.Sprintf("\n%s", section.text))
+	}
+
+	for _, example := range section.examples {
+		sb.WriteString(fmt.Sprintf("\n// %s", example))
+	}
+
+	return sb.String()
+}
+
+func formatPronunciations(pronunciations []entryPronunciation) string {
+	var sb strings.Builder
+
+	isFirst := true
+	for _, pronunciation := range pronunciations {
+		transcription := escapeHTML(strings.Join(pronunciation.transcriptions, ", "))
+		if pronunciation.audioUrl == "" && transcription == "" {
+			continue
+		}
+
+		if isFirst {
+			sb.WriteRune('\\')
+		}
+
+		if len(pronunciation.regions) > 0 {
+			sb.WriteString(fmt.Sprintf("<i>%s</i> ", escapeHTML(strings.Join(pronunciation.regions, ", "))))
+		}
+
+		if transcription != "" {
+			if pronunciation.audioUrl == "" {
+				sb.WriteString(transcription)
+			} else {
+				sb.WriteString(fmt.Sprintf("<a href=\"%s\">%s 🎧</a>", escapeHTMLAttribute(pronunciation.audioUrl), transcription))
+			}
+		} else {
+			sb.WriteString(fmt.Sprintf("<a href=\"%s\">🎧</a>", escapeHTMLAttribute(pronunciation.audioUrl)))
+		}
+
+		sb.WriteRune('\\')
+		isFirst = false
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	gzipFlagHeaderCRC = 1 << 1
+	gzipFlagExtra     = 1 << 2
+	gzipFlagName      = 1 << 3
+	gzipFlagComment   = 1 << 4
+)
+
+// articleReader reads articles of offline dictionaries by their offset and size
+type articleReader interface {
+	readArticle(offset int64, size int) ([]byte, error)
+}
+
+type plainArticleReader struct {
+	file *os.File
+}
+
+func (reader *plainArticleReader) readArticle(offset int64, size int) ([]byte, error) {
+	article := make([]byte, size)
+	_, err := reader.file.ReadAt(article, offset)
+	return article, err
+}
+
+type memoryArticleReader struct {
+	contents []byte
+}
+
+func (reader *memoryArticleReader) readArticle(offset int64, size int) ([]byte, error) {
+	if offset < 0 || offset+int64(size) > int64(len(reader.contents)) {
+		return nil, fmt.Errorf("article at %d of %d bytes is out of bounds", offset, size)
+	}
+
+	return reader.contents[offset : offset+int64(size)], nil
+}
+
+// dictzipReader reads articles right from a dictzip file, which is a gzip
+// file made of independently compressed chunks listed in the header
+type dictzipReader struct {
+	file        *os.File
+	chunkLength int
+	// Compressed chunk offsets in the file with the end of the last one
+	chunkOffsets []int64
+}
+
+// openArticleReader picks a reader by the file extension. Gzip files without
+// dictzip chunks are decompressed into memory as they can't be read randomly
+func openArticleReader(path string) (articleReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasSuffix(path, ".dz") {
+		return &plainArticleReader{file: file}, nil
+	}
+
+	reader, err := newDictzipReader(file)
+	if err == nil {
+		return reader, nil
+	}
+
+	defer file.Close()
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+		return nil, seekErr
+	}
+
+	contents, err := readGzip(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memoryArticleReader{contents: contents}, nil
+}
+
+func newDictzipReader(file *os.File) (*dictzipReader, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != 0x1f || header[1] != 0x8b || header[2] != 8 {
+		return nil, errors.New("not a gzip file")
+	}
+
+	flags := header[3]
+	if flags&gzipFlagExtra == 0 {
+		return nil, errors.New("gzip file has no dictzip chunks")
+	}
+
+	var extraLength uint16
+	if err := binary.Read(file, binary.LittleEndian, &extraLength); err != nil {
+		return nil, err
+	}
+
+	extra := make([]byte, extraLength)
+	if _, err := io.ReadFull(file, extra); err != nil {
+		return nil, err
+	}
+
+	reader := &dictzipReader{file: file}
+	var chunkSizes []uint16
+	for len(extra) >= 4 {
+		subfieldLength := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+subfieldLength {
+			break
+		}
+
+		subfield := extra[4 : 4+subfieldLength]
+		if extra[0] == 'R' && extra[1] == 'A' && len(subfield) >= 6 {
+			reader.chunkLength = int(binary.LittleEndian.Uint16(subfield[2:4]))
+			chunkCount := int(binary.LittleEndian.Uint16(subfield[4:6]))
+			for idx := 0; idx < chunkCount && 8+idx*2 <= len(subfield); idx++ {
+				chunkSizes = append(chunkSizes, binary.LittleEndian.Uint16(subfield[6+idx*2:8+idx*2]))
+			}
+		}
+
+		extra = extra[4+subfieldLength:]
+	}
+
+	if reader.chunkLength == 0 || len(chunkSizes) == 0 {
+		return nil, errors.New("gzip file has no dictzip chunks")
+	}
+
+	offset, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, flag := range []byte{gzipFlagName, gzipFlagComment} {
+		if flags&flag == 0 {
+			continue
+		}
+
+		// Zero terminated name or comment
+		buf := make([]byte, 1)
+		for {
+			if _, err := file.ReadAt(buf, offset); err != nil {
+				return nil, err
+			}
+
+			offset++
+			if buf[0] == 0 {
+				break
+			}
+		}
+	}
+
+	if flags&gzipFlagHeaderCRC != 0 {
+		offset += 2
+	}
+
+	for _, size := range chunkSizes {
+		reader.chunkOffsets = append(reader.chunkOffsets, offset)
+		offset += int64(size)
+	}
+
+	reader.chunkOffsets = append(reader.chunkOffsets, offset)
+	return reader, nil
+}
+
+func (reader *dictzipReader) readChunk(idx int) ([]byte, error) {
+	if idx < 0 || idx >= len(reader.chunkOffsets)-1 {
+		return nil, fmt.Errorf("dictzip chunk %d is out of bounds", idx)
+	}
+
+	start := reader.chunkOffsets[idx]
+	section := io.NewSectionReader(reader.file, start, reader.chunkOffsets[idx+1]-start)
+	decompressor := flate.NewReader(section)
+	defer decompressor.Close()
+
+	// Chunks are flushed but not finished, so the stream ends unexpectedly
+	chunk := make([]byte, reader.chunkLength)
+	read, err := io.ReadFull(decompressor, chunk)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return chunk[:read], nil
+}
+
+func (reader *dictzipReader) readArticle(offset int64, size int) ([]byte, error) {
+	if offset < 0 || size < 0 {
+		return nil, fmt.Errorf("article at %d of %d bytes is out of bounds", offset, size)
+	}
+
+	var article bytes.Buffer
+	first := int(offset / int64(reader.chunkLength))
+	last := int((offset + int64(size) - 1) / int64(reader.chunkLength))
+	for idx := first; idx <= last; idx++ {
+		chunk, err := reader.readChunk(idx)
+		if err != nil {
+			return nil, err
+		}
+
+		article.Write(chunk)
+	}
+
+	start := int(offset - int64(first*reader.chunkLength))
+	if start+size > article.Len() {
+		return nil, fmt.Errorf("article at %d of %d bytes is out of bounds", offset, size)
+	}
+
+	return article.Bytes()[start : start+size], nil
+}
+
+func readGzip(reader io.Reader) ([]byte, error) {
+	decompressor, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	defer decompressor.Close()
+	return ioutil.ReadAll(decompressor)
+}
+
+// readMaybeGzipFile reads the file or its gzipped version next to it
+func readMaybeGzipFile(path string) ([]byte, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err == nil || !os.IsNotExist(err) {
+		return contents, err
+	}
+
+	file, err := os.Open(path + ".gz")
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+	return readGzip(file)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeDictzip compresses every chunk on its own, as dictzip does, and lists
+// compressed chunk sizes in the "RA" extra field. Name is written if not empty
+func writeDictzip(t *testing.T, contents []byte, chunkLength int, name string) string {
+	var chunks [][]byte
+	for start := 0; start < len(contents); start += chunkLength {
+		end := start + chunkLength
+		if end > len(contents) {
+			end = len(contents)
+		}
+
+		var compressed bytes.Buffer
+		compressor, err := flate.NewWriter(&compressed, flate.BestCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		compressor.Write(contents[start:end])
+		if end == len(contents) {
+			compressor.Close()
+		} else {
+			compressor.Flush()
+		}
+
+		chunks = append(chunks, compressed.Bytes())
+	}
+
+	var subfield bytes.Buffer
+	binary.Write(&subfield, binary.LittleEndian, uint16(1))
+	binary.Write(&subfield, binary.LittleEndian, uint16(chunkLength))
+	binary.Write(&subfield, binary.LittleEndian, uint16(len(chunks)))
+	for _, chunk := range chunks {
+		binary.Write(&subfield, binary.LittleEndian, uint16(len(chunk)))
+	}
+
+	flags := byte(gzipFlagExtra)
+	if name != "" {
+		flags |= gzipFlagName
+	}
+
+	var file bytes.Buffer
+	file.Write([]byte{0x1f, 0x8b, 8, flags, 0, 0, 0, 0, 0, 0xff})
+	binary.Write(&file, binary.LittleEndian, uint16(4+subfield.Len()))
+	file.WriteString("RA")
+	binary.Write(&file, binary.LittleEndian, uint16(subfield.Len()))
+	file.Write(subfield.Bytes())
+	if name != "" {
+		file.WriteString(name)
+		file.WriteByte(0)
+	}
+
+	for _, chunk := range chunks {
+		file.Write(chunk)
+	}
+
+	binary.Write(&file, binary.LittleEndian, crc32.ChecksumIEEE(contents))
+	binary.Write(&file, binary.LittleEndian, uint32(len(contents)))
+
+	path := filepath.Join(t.TempDir(), "dictionary.dict.dz")
+	if err := ioutil.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestDictzipReader(t *testing.T) {
+	contents := []byte(strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 5))
+	const chunkLength = 16
+
+	tests := []struct {
+		name    string
+		offset  int64
+		size    int
+		wantErr bool
+	}{
+		{"start of first chunk", 0, 4, false},
+		{"within chunk", 3, 10, false},
+		{"whole chunk", chunkLength, chunkLength, false},
+		{"across chunks", 10, 30, false},
+		{"end of last chunk", int64(len(contents) - 5), 5, false},
+		{"whole file", 0, len(contents), false},
+		{"empty", 20, 0, false},
+		{"beyond the end", int64(len(contents) - 5), 6, true},
+		{"after the end", int64(len(contents) + chunkLength), 1, true},
+		{"negative offset", -1, 4, true},
+	}
+
+	for _, name := range []string{"", "dictionary.dict"} {
+		path := writeDictzip(t, contents, chunkLength, name)
+		reader, err := openArticleReader(path)
+		if err != nil {
+			t.Fatalf("openArticleReader() failed. %s", err)
+		}
+
+		if _, ok := reader.(*dictzipReader); !ok {
+			t.Fatalf("openArticleReader() = %T, want *dictzipReader", reader)
+		}
+
+		for _, test := range tests {
+			t.Run(name+"/"+test.name, func(t *testing.T) {
+				article, err := reader.readArticle(test.offset, test.size)
+				if test.wantErr {
+					if err == nil {
+						t.Errorf("readArticle(%d, %d) = %q, want error", test.offset, test.size, article)
+					}
+
+					return
+				}
+
+				if err != nil {
+					t.Fatalf("readArticle(%d, %d) failed. %s", test.offset, test.size, err)
+				}
+
+				want := contents[test.offset : test.offset+int64(test.size)]
+				if !bytes.Equal(article, want) {
+					t.Errorf("readArticle(%d, %d) = %q, want %q", test.offset, test.size, article, want)
+				}
+			})
+		}
+	}
+}
+
+func TestOpenArticleReaderPlainGzip(t *testing.T) {
+	contents := []byte("plain gzip without dictzip chunks")
+
+	var compressed bytes.Buffer
+	compressor := gzip.NewWriter(&compressed)
+	compressor.Write(contents)
+	compressor.Close()
+
+	path := filepath.Join(t.TempDir(), "dictionary.dict.dz")
+	if err := ioutil.WriteFile(path, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := openArticleReader(path)
+	if err != nil {
+		t.Fatalf("openArticleReader() failed. %s", err)
+	}
+
+	if _, ok := reader.(*memoryArticleReader); !ok {
+		t.Fatalf("openArticleReader() = %T, want *memoryArticleReader", reader)
+	}
+
+	article, err := reader.readArticle(6, 4)
+	if err != nil || string(article) != "gzip" {
+		t.Errorf("readArticle(6, 4) = %q, %v, want \"gzip\"", article, err)
+	}
+}
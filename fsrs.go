@@ -0,0 +1,186 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	fsrsDecay  = -0.5
+	fsrsFactor = 19.0 / 81.0
+	// Cards are scheduled when they are recalled with that probability
+	fsrsDesiredRetention = 0.9
+	fsrsMinDifficulty    = 1
+	fsrsMaxDifficulty    = 10
+	fsrsMinStability     = 0.01
+	fsrsWeightsCount     = 17
+
+	maxCachedFSRSWeights = 1000
+	fsrsWeightsLifeSpan  = time.Hour
+)
+
+var (
+	// FSRS-4.5 weights fitted on reviews of many users
+	defaultFSRSWeights = fsrsWeights{
+		0.4872, 1.4003, 3.7145, 13.8206,
+		5.1618, 1.2298, 0.8975, 0.031,
+		1.6474, 0.1367, 1.0461,
+		2.1072, 0.0793, 0.3246, 1.587,
+		0.2272, 2.8755,
+	}
+
+	// Optimized weights by user ID
+	fsrsUserWeights = newCache("fsrs_weights", maxCachedFSRSWeights, fsrsWeightsLifeSpan)
+)
+
+// fsrsWeights are parameters of Free Spaced Repetition Scheduler: initial
+// stability by grade (0-3), difficulty (4-7), stability after recall (8-10),
+// stability after lapse (11-14), hard penalty (15) and easy bonus (16)
+type fsrsWeights []float64
+
+type fsrsState struct {
+	difficulty float64
+	stability  float64
+}
+
+// fsrsScheduler keeps difficulty and stability of every card. Stability is the
+// interval in days at which the recall probability drops to 90%
+type fsrsScheduler struct {
+	weights fsrsWeights
+}
+
+func (fsrsScheduler) name() string {
+	return fsrsSchedulerName
+}
+
+func (fsrsScheduler) forUser(userID int) scheduler {
+	return fsrsScheduler{weights: fsrsWeightsFor(userID)}
+}
+
+// fsrsWeightsFor returns weights optimized for the user or the default ones
+func fsrsWeightsFor(userID int) fsrsWeights {
+	key := strconv.Itoa(userID)
+	if cached, ok := fsrsUserWeights.get(key); ok {
+		return cached.(fsrsWeights)
+	}
+
+	if db == nil {
+		return defaultFSRSWeights
+	}
+
+	weights, err := getFSRSWeights(db, userID)
+	if err != nil {
+		return defaultFSRSWeights
+	}
+
+	if len(weights) != fsrsWeightsCount {
+		weights = defaultFSRSWeights
+	}
+
+	fsrsUserWeights.set(key, weights)
+	return weights
+}
+
+// New card has no state till its first review
+func (fsrsScheduler) start(data *trainingData, now time.Time) int {
+	data.Difficulty = 0
+	data.Stability = 0
+	data.Retrievability = 0
+	data.Reviewed = nil
+	return 1
+}
+
+func (scheduler fsrsScheduler) review(data *trainingData, grade reviewGrade, now time.Time) (int, bool) {
+	var state fsrsState
+	if data.Stability == 0 || data.Reviewed == nil {
+		state = scheduler.weights.initialState(grade)
+		data.Retrievability = 0
+	} else {
+		elapsedDays := now.Sub(*data.Reviewed).Hours() / 24
+		state, data.Retrievability = scheduler.weights.nextState(fsrsState{
+			difficulty: data.Difficulty,
+			stability:  data.Stability,
+		}, grade, elapsedDays)
+	}
+
+	data.Difficulty = state.difficulty
+	data.Stability = state.stability
+	data.Reviewed = &now
+	data.Iteration++
+
+	days := fsrsInterval(state.stability)
+	return days, days > retirementInterval
+}
+
+// fsrsRetrievability is the probability to recall a card with the stability
+// after the elapsed days
+func fsrsRetrievability(elapsedDays float64, stability float64) float64 {
+	if elapsedDays < 0 {
+		elapsedDays = 0
+	}
+
+	return math.Pow(1+fsrsFactor*elapsedDays/stability, fsrsDecay)
+}
+
+func fsrsInterval(stability float64) int {
+	days := int(math.Round(stability / fsrsFactor * (math.Pow(fsrsDesiredRetention, 1/fsrsDecay) - 1)))
+	if days < 1 {
+		return 1
+	}
+
+	return days
+}
+
+func clampFSRSDifficulty(difficulty float64) float64 {
+	return math.Min(math.Max(difficulty, fsrsMinDifficulty), fsrsMaxDifficulty)
+}
+
+func (weights fsrsWeights) initialDifficulty(grade reviewGrade) float64 {
+	return weights[4] - float64(grade-gradeGood)*weights[5]
+}
+
+func (weights fsrsWeights) initialState(grade reviewGrade) fsrsState {
+	return fsrsState{
+		difficulty: clampFSRSDifficulty(weights.initialDifficulty(grade)),
+		stability:  math.Max(weights[grade-gradeAgain], fsrsMinStability),
+	}
+}
+
+// nextState returns the state after the review and the recall probability
+// the card had at the moment of the review
+func (weights fsrsWeights) nextState(state fsrsState, grade reviewGrade, elapsedDays float64) (fsrsState, float64) {
+	retrievability := fsrsRetrievability(elapsedDays, state.stability)
+
+	// Difficulty changes by the grade and reverts to the initial one of "good"
+	difficulty := state.difficulty - weights[6]*float64(grade-gradeGood)
+	difficulty = weights[7]*weights.initialDifficulty(gradeGood) + (1-weights[7])*difficulty
+
+	var stability float64
+	if grade == gradeAgain {
+		stability = weights[11] *
+			math.Pow(state.difficulty, -weights[12]) *
+			(math.Pow(state.stability+1, weights[13]) - 1) *
+			math.Exp(weights[14]*(1-retrievability))
+		// Forgotten card is never more stable than it was
+		stability = math.Min(stability, state.stability)
+	} else {
+		modifier := 1.0
+		if grade == gradeHard {
+			modifier = weights[15]
+		} else if grade == gradeEasy {
+			modifier = weights[16]
+		}
+
+		stability = state.stability * (1 + math.Exp(weights[8])*
+			(11-state.difficulty)*
+			math.Pow(state.stability, -weights[9])*
+			(math.Exp(weights[10]*(1-retrievability))-1)*
+			modifier)
+	}
+
+	return fsrsState{
+		difficulty: clampFSRSDifficulty(difficulty),
+		stability:  math.Max(stability, fsrsMinStability),
+	}, retrievability
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+const (
+	optimizeCommand = "/optimize"
+
+	// Fewer reviews would rather fit the noise than the user's memory
+	fsrsMinReviewsToOptimize = 50
+	fsrsOptimizerIterations  = 200
+	// Step of every weight relative to its value
+	fsrsLearningRate = 0.02
+	// Keeps weights close to the default ones unless reviews say otherwise
+	fsrsRegularization = 0.01
+	fsrsAdamBeta1      = 0.9
+	fsrsAdamBeta2      = 0.999
+	fsrsAdamEpsilon    = 1e-8
+	// Optimization is heavy, so a user may start it once in the interval
+	fsrsOptimizationInterval = 10 * time.Minute
+)
+
+// Optimizations running in the background by user ID, an entry is kept until
+// the optimization finishes however long it takes
+var (
+	runningFSRSOptimizationsMu sync.Mutex
+	runningFSRSOptimizations   = map[int]bool{}
+)
+
+// Optimizations started recently by user ID
+var recentFSRSOptimizations = newCache("fsrs_optimizations", 0, fsrsOptimizationInterval)
+
+// Weights are kept within ranges which make sense for the formulas
+var fsrsWeightBounds = [fsrsWeightsCount][2]float64{
+	{0.1, 100}, {0.1, 100}, {0.1, 100}, {0.1, 100},
+	{1, 10}, {0.1, 5}, {0.1, 5}, {0, 0.5},
+	{0, 3}, {0.1, 0.8}, {0.01, 2.5},
+	{0.5, 5}, {0.01, 0.2}, {0.01, 0.9}, {0.01, 2},
+	{0, 1}, {1, 6},
+}
+
+// reviewOutcome is a grade the user gave to a card at the given moment
+type reviewOutcome struct {
+	cardID     int64
+	reviewedAt time.Time
+	grade      reviewGrade
+}
+
+// groupReviewsByCard splits outcomes ordered by card and time into histories
+// of every card
+func groupReviewsByCard(outcomes []reviewOutcome) [][]reviewOutcome {
+	var cards [][]reviewOutcome
+	for idx, outcome := range outcomes {
+		if idx == 0 || outcomes[idx-1].cardID != outcome.cardID {
+			cards = append(cards, nil)
+		}
+
+		cards[len(cards)-1] = append(cards[len(cards)-1], outcome)
+	}
+
+	return cards
+}
+
+// replay goes through the card history and calls visit with the predicted
+// recall probability and the actual outcome of every review but the first one.
+// Reviews made before the log was kept are unknown, so the first logged
+// review is taken for the first one
+func (weights fsrsWeights) replay(reviews []reviewOutcome, visit func(retrievability float64, recalled bool)) fsrsState {
+	state := weights.initialState(reviews[0].grade)
+	for idx := 1; idx < len(reviews); idx++ {
+		elapsedDays := reviews[idx].reviewedAt.Sub(reviews[idx-1].reviewedAt).Hours() / 24
+
+		var retrievability float64
+		state, retrievability = weights.nextState(state, reviews[idx].grade, elapsedDays)
+		if visit != nil {
+			visit(retrievability, reviews[idx].grade != gradeAgain)
+		}
+	}
+
+	return state
+}
+
+// fsrsLoss is the mean log loss of recall predictions and the number of
+// predicted reviews
+func fsrsLoss(weights fsrsWeights, cards [][]reviewOutcome) (float64, int) {
+	loss := 0.0
+	count := 0
+	for _, reviews := range cards {
+		weights.replay(reviews, func(retrievability float64, recalled bool) {
+			retrievability = math.Min(math.Max(retrievability, 1e-6), 1-1e-6)
+			if recalled {
+				loss -= math.Log(retrievability)
+			} else {
+				loss -= math.Log(1 - retrievability)
+			}
+
+			count++
+		})
+	}
+
+	if count == 0 {
+		return 0, 0
+	}
+
+	return loss / float64(count), count
+}
+
+func fsrsRegularizedLoss(weights fsrsWeights, cards [][]reviewOutcome) float64 {
+	loss, _ := fsrsLoss(weights, cards)
+	for idx, weight := range weights {
+		deviation := (weight - defaultFSRSWeights[idx]) / math.Max(defaultFSRSWeights[idx], 0.1)
+		loss += fsrsRegularization * deviation * deviation
+	}
+
+	return loss
+}
+
+func clampFSRSWeights(weights fsrsWeights) {
+	for idx := range weights {
+		weights[idx] = math.Min(math.Max(weights[idx], fsrsWeightBounds[idx][0]), fsrsWeightBounds[idx][1])
+	}
+}
+
+// optimizeFSRSWeights fits weights to the review histories with Adam using
+// numerical gradients, which is fast enough for histories of a single user
+func optimizeFSRSWeights(cards [][]reviewOutcome, initial fsrsWeights) fsrsWeights {
+	weights := append(fsrsWeights(nil), initial...)
+	clampFSRSWeights(weights)
+
+	firstMoments := make([]float64, len(weights))
+	secondMoments := make([]float64, len(weights))
+	gradient := make([]float64, len(weights))
+	probe := make(fsrsWeights, len(weights))
+
+	for iteration := 1; iteration <= fsrsOptimizerIterations; iteration++ {
+		for idx := range weights {
+			step := 1e-4 * math.Max(math.Abs(weights[idx]), 0.1)
+
+			copy(probe, weights)
+			probe[idx] = weights[idx] + step
+			upper := fsrsRegularizedLoss(probe, cards)
+			probe[idx] = weights[idx] - step
+			lower := fsrsRegularizedLoss(probe, cards)
+
+			gradient[idx] = (upper - lower) / (2 * step)
+		}
+
+		for idx := range weights {
+			firstMoments[idx] = fsrsAdamBeta1*firstMoments[idx] + (1-fsrsAdamBeta1)*gradient[idx]
+			secondMoments[idx] = fsrsAdamBeta2*secondMoments[idx] + (1-fsrsAdamBeta2)*gradient[idx]*gradient[idx]
+
+			firstMoment := firstMoments[idx] / (1 - math.Pow(fsrsAdamBeta1, float64(iteration)))
+			secondMoment := secondMoments[idx] / (1 - math.Pow(fsrsAdamBeta2, float64(iteration)))
+
+			learningRate := fsrsLearningRate * math.Max(math.Abs(weights[idx]), 0.1)
+			weights[idx] -= learningRate * firstMoment / (math.Sqrt(secondMoment) + fsrsAdamEpsilon)
+		}
+
+		clampFSRSWeights(weights)
+	}
+
+	return weights
+}
+
+// predictedRetention is the mean probability to recall the cards right now
+func predictedRetention(weights fsrsWeights, cards [][]reviewOutcome, now time.Time) float64 {
+	if len(cards) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, reviews := range cards {
+		state := weights.replay(reviews, nil)
+		elapsedDays := now.Sub(reviews[len(reviews)-1].reviewedAt).Hours() / 24
+		total += fsrsRetrievability(elapsedDays, state.stability)
+	}
+
+	return total / float64(len(cards))
+}
+
+func recallRate(cards [][]reviewOutcome) float64 {
+	recalled := 0
+	count := 0
+	for _, reviews := range cards {
+		for _, review := range reviews[1:] {
+			if review.grade != gradeAgain {
+				recalled++
+			}
+
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	return float64(recalled) / float64(count)
+}
+
+// handleOptimizeCommand fits FSRS weights to the reviews of the user in the
+// background and reports how well the user is predicted to remember the
+// cards once done. A user runs a single optimization at a time
+func handleOptimizeCommand(inMessage *tgbotapi.Message) {
+	userID := inMessage.From.ID
+
+	runningFSRSOptimizationsMu.Lock()
+	if runningFSRSOptimizations[userID] {
+		runningFSRSOptimizationsMu.Unlock()
+		sendSimpleReply(inMessage, "Your FSRS parameters are being optimized already ... ⏳")
+		return
+	}
+
+	if !recentFSRSOptimizations.add(strconv.Itoa(userID), true) {
+		runningFSRSOptimizationsMu.Unlock()
+		sendSimpleReply(inMessage, "Your FSRS parameters were optimized recently ... Try again in a while ⏳")
+		return
+	}
+
+	runningFSRSOptimizations[userID] = true
+	runningFSRSOptimizationsMu.Unlock()
+
+	sendSimpleReply(inMessage, "Optimizing your FSRS parameters, it may take a while ... ⏳")
+
+	go func() {
+		defer func() {
+			runningFSRSOptimizationsMu.Lock()
+			delete(runningFSRSOptimizations, userID)
+			runningFSRSOptimizationsMu.Unlock()
+		}()
+
+		optimizeUserFSRSWeights(inMessage)
+	}()
+}
+
+func optimizeUserFSRSWeights(inMessage *tgbotapi.Message) {
+	userID := inMessage.From.ID
+	outcomes, err := getUserReviewOutcomes(db, userID)
+	if err != nil {
+		handleErrorWithReply(inMessage, err)
+		return
+	}
+
+	cards := groupReviewsByCard(outcomes)
+	defaultLoss, count := fsrsLoss(defaultFSRSWeights, cards)
+	if count < fsrsMinReviewsToOptimize {
+		sendSimpleReply(inMessage, fmt.Sprintf("Not enough reviews to optimize yet ... %d of %d 📚 Keep training with %s!", count, fsrsMinReviewsToOptimize, trainCommand))
+		return
+	}
+
+	weights := optimizeFSRSWeights(cards, defaultFSRSWeights)
+	loss, _ := fsrsLoss(weights, cards)
+
+	err = storeFSRSWeights(db, userID, weights, count)
+	if err != nil {
+		handleErrorWithReply(inMessage, err)
+		return
+	}
+
+	fsrsUserWeights.set(strconv.Itoa(userID), weights)
+
+	sendSimpleReply(inMessage, fmt.Sprintf(
+		"Optimized your FSRS parameters on %d reviews of %d cards 🧮\n"+
+			"Prediction loss: %.4f → %.4f\n"+
+			"Actual recall rate: %.1f%%\n"+
+			"Predicted retention right now: %.1f%%",
+		count, len(cards),
+		defaultLoss, loss,
+		recallRate(cards)*100,
+		predictedRetention(weights, cards, time.Now())*100))
+}
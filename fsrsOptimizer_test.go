@@ -0,0 +1,144 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testReviewStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// testReviews makes a history of the card with grades given after the days
+// passed since the previous review
+func testReviews(cardID int64, days []int, grades []reviewGrade) []reviewOutcome {
+	var reviews []reviewOutcome
+	reviewedAt := testReviewStart
+	for idx, grade := range grades {
+		reviewedAt = reviewedAt.AddDate(0, 0, days[idx])
+		reviews = append(reviews, reviewOutcome{cardID: cardID, reviewedAt: reviewedAt, grade: grade})
+	}
+
+	return reviews
+}
+
+func TestGroupReviewsByCard(t *testing.T) {
+	first := testReviews(1, []int{0, 1}, []reviewGrade{gradeGood, gradeGood})
+	second := testReviews(2, []int{0}, []reviewGrade{gradeAgain})
+
+	tests := []struct {
+		name     string
+		outcomes []reviewOutcome
+		want     [][]reviewOutcome
+	}{
+		{"none", nil, nil},
+		{"single card", first, [][]reviewOutcome{first}},
+		{"cards", append(append([]reviewOutcome(nil), first...), second...), [][]reviewOutcome{first, second}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := groupReviewsByCard(test.outcomes); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("groupReviewsByCard() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFSRSLoss(t *testing.T) {
+	goodStability := defaultFSRSWeights[gradeGood-gradeAgain]
+	recalled := fsrsRetrievability(3, goodStability)
+
+	tests := []struct {
+		name      string
+		cards     [][]reviewOutcome
+		wantLoss  float64
+		wantCount int
+	}{
+		{"no reviews", nil, 0, 0},
+		{"first reviews only", [][]reviewOutcome{
+			testReviews(1, []int{0}, []reviewGrade{gradeGood}),
+		}, 0, 0},
+		{"recalled", [][]reviewOutcome{
+			testReviews(1, []int{0, 3}, []reviewGrade{gradeGood, gradeGood}),
+		}, -math.Log(recalled), 1},
+		{"forgotten", [][]reviewOutcome{
+			testReviews(1, []int{0, 3}, []reviewGrade{gradeGood, gradeAgain}),
+		}, -math.Log(1 - recalled), 1},
+		{"mean of cards", [][]reviewOutcome{
+			testReviews(1, []int{0, 3}, []reviewGrade{gradeGood, gradeGood}),
+			testReviews(2, []int{0, 3}, []reviewGrade{gradeGood, gradeAgain}),
+		}, -(math.Log(recalled) + math.Log(1-recalled)) / 2, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			loss, count := fsrsLoss(defaultFSRSWeights, test.cards)
+			if math.Abs(loss-test.wantLoss) > 1e-9 || count != test.wantCount {
+				t.Errorf("fsrsLoss() = %v, %d, want %v, %d", loss, count, test.wantLoss, test.wantCount)
+			}
+		})
+	}
+}
+
+func TestOptimizeFSRSWeights(t *testing.T) {
+	tests := []struct {
+		name   string
+		days   []int
+		grades []reviewGrade
+	}{
+		{"remembers better than predicted", []int{0, 10, 40, 120}, []reviewGrade{gradeGood, gradeGood, gradeGood, gradeGood}},
+		{"forgets sooner than predicted", []int{0, 2, 3, 3}, []reviewGrade{gradeGood, gradeAgain, gradeAgain, gradeAgain}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var cards [][]reviewOutcome
+			for cardID := int64(1); cardID <= 20; cardID++ {
+				cards = append(cards, testReviews(cardID, test.days, test.grades))
+			}
+
+			weights := optimizeFSRSWeights(cards, defaultFSRSWeights)
+			if len(weights) != fsrsWeightsCount {
+				t.Fatalf("optimizeFSRSWeights() returned %d weights, want %d", len(weights), fsrsWeightsCount)
+			}
+
+			for idx, weight := range weights {
+				if weight < fsrsWeightBounds[idx][0] || weight > fsrsWeightBounds[idx][1] {
+					t.Errorf("weight %d = %v is out of %v", idx, weight, fsrsWeightBounds[idx])
+				}
+			}
+
+			defaultLoss, _ := fsrsLoss(defaultFSRSWeights, cards)
+			loss, _ := fsrsLoss(weights, cards)
+			if loss >= defaultLoss {
+				t.Errorf("optimized loss %v is not below default loss %v", loss, defaultLoss)
+			}
+		})
+	}
+}
+
+func TestRecallRate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cards [][]reviewOutcome
+		want  float64
+	}{
+		{"no reviews", nil, 0},
+		{"first review is not counted", [][]reviewOutcome{
+			testReviews(1, []int{0, 1}, []reviewGrade{gradeAgain, gradeGood}),
+		}, 1},
+		{"lapses", [][]reviewOutcome{
+			testReviews(1, []int{0, 1, 1}, []reviewGrade{gradeGood, gradeAgain, gradeHard}),
+			testReviews(2, []int{0, 1}, []reviewGrade{gradeGood, gradeAgain}),
+		}, 1.0 / 3.0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := recallRate(test.cards); math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("recallRate() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
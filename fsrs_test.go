@@ -0,0 +1,146 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFSRSRetrievability(t *testing.T) {
+	tests := []struct {
+		name        string
+		elapsedDays float64
+		stability   float64
+		want        float64
+	}{
+		{"just reviewed", 0, 10, 1},
+		{"reviewed in the future", -1, 10, 1},
+		{"after stability", 10, 10, fsrsDesiredRetention},
+		{"after stability of a day", 1, 1, fsrsDesiredRetention},
+		{"after a long time", 810, 10, 1 / math.Sqrt(20)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := fsrsRetrievability(test.elapsedDays, test.stability); math.Abs(got-test.want) > 1e-9 {
+				t.Errorf("fsrsRetrievability(%v, %v) = %v, want %v", test.elapsedDays, test.stability, got, test.want)
+			}
+		})
+	}
+}
+
+func TestFSRSInterval(t *testing.T) {
+	tests := []struct {
+		stability float64
+		want      int
+	}{
+		{0.01, 1},
+		{0.4, 1},
+		{1, 1},
+		{3.7145, 4},
+		{13.8206, 14},
+		{100, 100},
+	}
+
+	for _, test := range tests {
+		if got := fsrsInterval(test.stability); got != test.want {
+			t.Errorf("fsrsInterval(%v) = %d, want %d", test.stability, got, test.want)
+		}
+	}
+}
+
+func TestFSRSInitialState(t *testing.T) {
+	tests := []struct {
+		grade          reviewGrade
+		wantDifficulty float64
+		wantStability  float64
+	}{
+		{gradeAgain, 7.6214, 0.4872},
+		{gradeHard, 6.3916, 1.4003},
+		{gradeGood, 5.1618, 3.7145},
+		{gradeEasy, 3.932, 13.8206},
+	}
+
+	for _, test := range tests {
+		t.Run(test.grade.String(), func(t *testing.T) {
+			state := defaultFSRSWeights.initialState(test.grade)
+			if math.Abs(state.difficulty-test.wantDifficulty) > 1e-9 || math.Abs(state.stability-test.wantStability) > 1e-9 {
+				t.Errorf("initialState(%s) = %+v, want difficulty %v and stability %v", test.grade, state, test.wantDifficulty, test.wantStability)
+			}
+		})
+	}
+}
+
+func TestFSRSNextState(t *testing.T) {
+	state := fsrsState{difficulty: 5, stability: 10}
+
+	tests := []struct {
+		grade       reviewGrade
+		elapsedDays float64
+		// Stability relative to the one before the review
+		wantMoreStable bool
+		// Difficulty relative to the one before the review
+		wantHarder bool
+	}{
+		{gradeAgain, 10, false, true},
+		{gradeHard, 10, true, true},
+		{gradeGood, 10, true, true},
+		{gradeEasy, 10, true, false},
+	}
+
+	var previous fsrsState
+	for idx, test := range tests {
+		t.Run(test.grade.String(), func(t *testing.T) {
+			next, retrievability := defaultFSRSWeights.nextState(state, test.grade, test.elapsedDays)
+			if math.Abs(retrievability-fsrsDesiredRetention) > 1e-9 {
+				t.Errorf("retrievability = %v, want %v", retrievability, fsrsDesiredRetention)
+			}
+
+			if (next.stability > state.stability) != test.wantMoreStable {
+				t.Errorf("stability %v -> %v, want more stable %v", state.stability, next.stability, test.wantMoreStable)
+			}
+
+			if (next.difficulty > state.difficulty) != test.wantHarder {
+				t.Errorf("difficulty %v -> %v, want harder %v", state.difficulty, next.difficulty, test.wantHarder)
+			}
+
+			// Better grade never makes the card less stable or harder
+			if idx > 0 && (next.stability < previous.stability || next.difficulty > previous.difficulty) {
+				t.Errorf("%s gives %+v, worse than %+v of %s", test.grade, next, previous, tests[idx-1].grade)
+			}
+
+			previous = next
+		})
+	}
+}
+
+func TestFSRSSchedulerReview(t *testing.T) {
+	scheduler := fsrsScheduler{weights: defaultFSRSWeights}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var data trainingData
+	scheduler.start(&data, now)
+
+	tests := []struct {
+		name        string
+		elapsedDays int
+		grade       reviewGrade
+		wantDays    int
+	}{
+		{"first review", 0, gradeGood, 4},
+		{"on time", 4, gradeGood, 15},
+		{"forgotten", 15, gradeAgain, 3},
+	}
+
+	for _, test := range tests {
+		now = now.AddDate(0, 0, test.elapsedDays)
+		days, retired := scheduler.review(&data, test.grade, now)
+		if days != test.wantDays || retired {
+			t.Errorf("%s: review() = %d days, retired %v, want %d days", test.name, days, retired, test.wantDays)
+		}
+
+		if data.Reviewed == nil || !data.Reviewed.Equal(now) {
+			t.Errorf("%s: reviewed at %v, want %v", test.name, data.Reviewed, now)
+		}
+	}
+}
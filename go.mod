@@ -1,4 +1,4 @@
-module main
+module github.com/Medvedev-Andrey/ezVocabulator
 
 go 1.17
 
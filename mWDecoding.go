@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var (
+	errUnknownMWElement = errors.New("unknown element")
+)
+
+func reportMWDecodingIssue(element string, err error) {
+	log.Printf("Skipping malformed Merriam Webster '%s' element. %s", element, err)
+	mWDecodingIssues.Add(element, 1)
+}
+
+// forEachMWElement walks MW array of ["name", value] pairs. Malformed and unknown
+// pairs are reported and skipped, so the rest of the array is still decoded
+func forEachMWElement(data []byte, element string, handle func(name string, value json.RawMessage) error) error {
+	var contents []json.RawMessage
+	err := json.Unmarshal(data, &contents)
+	if err != nil {
+		return err
+	}
+
+	for _, content := range contents {
+		var subcontents []json.RawMessage
+		err = json.Unmarshal(content, &subcontents)
+		if err != nil {
+			reportMWDecodingIssue(element, err)
+			continue
+		}
+
+		if len(subcontents) != 2 {
+			reportMWDecodingIssue(element, fmt.Errorf("expected name and value pair, got %d parts", len(subcontents)))
+			continue
+		}
+
+		var name string
+		err = json.Unmarshal(subcontents[0], &name)
+		if err != nil {
+			reportMWDecodingIssue(element, err)
+			continue
+		}
+
+		err = handle(name, subcontents[1])
+		if err != nil {
+			reportMWDecodingIssue(fmt.Sprintf("%s.%s", element, name), err)
+		}
+	}
+
+	return nil
+}
+
+// unmarshalMWObject decodes MW object field by field, so a single malformed field
+// doesn't cost the whole object. Target has to be a pointer to a struct type
+// without custom unmarshalling
+func unmarshalMWObject(data []byte, element string, target interface{}) error {
+	var fields map[string]json.RawMessage
+	err := json.Unmarshal(data, &fields)
+	if err != nil {
+		return err
+	}
+
+	for name, fieldData := range fields {
+		field, _ := json.Marshal(map[string]json.RawMessage{name: fieldData})
+		err = json.Unmarshal(field, target)
+		if err != nil {
+			reportMWDecodingIssue(fmt.Sprintf("%s.%s", element, name), err)
+		}
+	}
+
+	return nil
+}
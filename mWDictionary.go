@@ -1,28 +1,93 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
-var (
-	mWApiToken string
+const (
+	mWProviderName          = "merriam-webster"
+	mWLearnersProviderName  = "merriam-webster-learners"
+	mWThesaurusProviderName = "merriam-webster-thesaurus"
+	mWRequestFormat         = "https://dictionaryapi.com/api/v3/references/%s/json/%s?key=%s"
+	mWAudioLinkFormat       = "https://media.merriam-webster.com/audio/prons/en/us/mp3/%s/%s.mp3"
+	maxMWThesaurusWords     = 10
 )
 
-const (
-	mWRequestFormat   = "https://dictionaryapi.com/api/v3/references/collegiate/json/%s?key=%s"
-	mWAudioLinkFormat = "https://media.merriam-webster.com/audio/prons/en/us/mp3/%s/%s.mp3"
+// mWReference is one of MW dictionaries served by the same API. Every
+// reference has its own API key
+type mWReference struct {
+	providerName string
+	// Reference name in request URL
+	name          string
+	tokenVariable string
+}
+
+var (
+	mWCollegiateReference = mWReference{
+		providerName:  mWProviderName,
+		name:          "collegiate",
+		tokenVariable: "MW_DICTIONARY_API_TOKEN",
+	}
+
+	// Simpler definitions aimed at language learners
+	mWLearnersReference = mWReference{
+		providerName:  mWLearnersProviderName,
+		name:          "learners",
+		tokenVariable: "MW_LEARNERS_API_TOKEN",
+	}
+
+	// Synonyms and antonyms of every sense
+	mWThesaurusReference = mWReference{
+		providerName:  mWThesaurusProviderName,
+		name:          "thesaurus",
+		tokenVariable: "MW_THESAURUS_API_TOKEN",
+	}
 )
 
 type mWDictionaryResponse struct {
 	Entries []mWEntry
+	// Words suggested instead of the requested one when nothing matches it
+	Suggestions []string
+}
+
+func (s *mWDictionaryResponse) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	err := json.Unmarshal(data, &items)
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 0 {
+		return nil
+	}
+
+	var suggestion string
+	if json.Unmarshal(items[0], &suggestion) == nil {
+		return json.Unmarshal(data, &s.Suggestions)
+	}
+
+	for _, item := range items {
+		var entry mWEntry
+		err = json.Unmarshal(item, &entry)
+		if err != nil {
+			reportMWDecodingIssue("entry", err)
+			continue
+		}
+
+		s.Entries = append(s.Entries, entry)
+	}
+
+	return nil
 }
 
 type mWEntry struct {
@@ -32,6 +97,62 @@ type mWEntry struct {
 	Inflections        []mWInflection         `json:"ins"`
 	GrammaticalNote    string                 `json:"gram"`
 	DefinitionSections []mWDefinitionsSection `json:"def"`
+	Etymology          mWEtymology            `json:"et"`
+	FirstKnownUse      string                 `json:"date"`
+	SynonymsParagraphs []mWSynonymsParagraph  `json:"syns"`
+	Quotes             []mWQuote              `json:"quotes"`
+	DefinedRunOns      []mWDefinedRunOn       `json:"dros"`
+	UndefinedRunOns    []mWUndefinedRunOn     `json:"uros"`
+	// Entries of inflected forms (e.g. "ran") often consist of cognate cross-references only
+	CognateCrossReferences []mWCognateCrossReference `json:"cxs"`
+	GeneralLabels          []string                  `json:"lbs"`
+}
+
+func (s *mWEntry) UnmarshalJSON(data []byte) error {
+	type plainMWEntry mWEntry
+	return unmarshalMWObject(data, "entry", (*plainMWEntry)(s))
+}
+
+type mWCognateCrossReference struct {
+	Label   string                          `json:"cxl"`
+	Targets []mWCognateCrossReferenceTarget `json:"cxtis"`
+}
+
+type mWCognateCrossReferenceTarget struct {
+	Label string `json:"cxl"`
+	Text  string `json:"cxt"`
+	// Sense number of the target to which the reference points
+	SenseNumber string `json:"cxn"`
+}
+
+// Phrase (e.g. phrasal verb or idiom) defined within the entry of its headword
+type mWDefinedRunOn struct {
+	Phrase          string `json:"drp"`
+	PartOfSpeech    string `json:"fl"`
+	GrammaticalNote string `json:"gram"`
+	// Learner's grammatical note on the phrase
+	WordGrammaticalNote string                 `json:"wsgram"`
+	DefinitionSections  []mWDefinitionsSection `json:"def"`
+	GeneralLabels       []string               `json:"lbs"`
+}
+
+func (s *mWDefinedRunOn) UnmarshalJSON(data []byte) error {
+	type plainMWDefinedRunOn mWDefinedRunOn
+	return unmarshalMWObject(data, "dros", (*plainMWDefinedRunOn)(s))
+}
+
+// Derived form (e.g. adverb or noun) listed without definition within the entry of its headword
+type mWUndefinedRunOn struct {
+	Word           string            `json:"ure"`
+	PartOfSpeech   string            `json:"fl"`
+	Pronunciations []mWPronunciation `json:"prs"`
+	Text           mWDefiningText    `json:"utxt"`
+	GeneralLabels  []string          `json:"lbs"`
+}
+
+func (s *mWUndefinedRunOn) UnmarshalJSON(data []byte) error {
+	type plainMWUndefinedRunOn mWUndefinedRunOn
+	return unmarshalMWObject(data, "uros", (*plainMWUndefinedRunOn)(s))
 }
 
 type mWInflection struct {
@@ -71,6 +192,11 @@ type mWDefinitionsSection struct {
 	VerbDivider   string              `json:"vd"`
 }
 
+func (s *mWDefinitionsSection) UnmarshalJSON(data []byte) error {
+	type plainMWDefinitionsSection mWDefinitionsSection
+	return unmarshalMWObject(data, "def", (*plainMWDefinitionsSection)(s))
+}
+
 type mWSenseStatusLabels struct {
 	Labels []string
 }
@@ -100,13 +226,15 @@ type mWSensesSequence struct {
 }
 
 type mWSensesSequenceItem struct {
+	// Truncated sense carries labels shared by the following senses without a definition
+	TruncatedSense              *mWSense
 	BindingSubstitution         *mWBindingSubstitution
 	ParenthesizedSenseSequences []mWParenthesizedSenseSequence
 	Senses                      []mWSense
 }
 
 func (s *mWSensesSequence) UnmarshalJSON(data []byte) error {
-	var sequence []*json.RawMessage
+	var sequence []json.RawMessage
 	err := json.Unmarshal(data, &sequence)
 	if err != nil {
 		return err
@@ -115,53 +243,50 @@ func (s *mWSensesSequence) UnmarshalJSON(data []byte) error {
 	for _, section := range sequence {
 		var sensesSequenceItem mWSensesSequenceItem
 
-		var subsequence []*json.RawMessage
-		err = json.Unmarshal(*section, &subsequence)
-		if err != nil {
-			return err
-		}
-
-		for _, item := range subsequence {
-			var itemParts []*json.RawMessage
-			err = json.Unmarshal(*item, &itemParts)
-			if err != nil {
-				return err
-			}
-
-			if len(itemParts) != 2 {
-				continue
-			}
-
-			var sectionName string
-			err = json.Unmarshal(*itemParts[0], &sectionName)
-			if err != nil {
-				return err
-			}
-
+		err = forEachMWElement(section, "sseq", func(name string, value json.RawMessage) error {
 			switch {
-			case sectionName == "bs":
+			case name == "sen":
+				var truncatedSense mWSense
+				err := json.Unmarshal(value, &truncatedSense)
+				if err != nil {
+					return err
+				}
+
+				sensesSequenceItem.TruncatedSense = &truncatedSense
+			case name == "bs":
 				var bindingSubstitution mWBindingSubstitution
-				err = json.Unmarshal(*itemParts[1], &bindingSubstitution)
-				if err == nil {
-					sensesSequenceItem.BindingSubstitution = &bindingSubstitution
+				err := json.Unmarshal(value, &bindingSubstitution)
+				if err != nil {
+					return err
 				}
-			case sectionName == "sense":
+
+				sensesSequenceItem.BindingSubstitution = &bindingSubstitution
+			case name == "sense":
 				var sense mWSense
-				err = json.Unmarshal(*itemParts[1], &sense)
-				if err == nil {
-					sensesSequenceItem.Senses = append(sensesSequenceItem.Senses, sense)
+				err := json.Unmarshal(value, &sense)
+				if err != nil {
+					return err
 				}
-			case sectionName == "pseq":
+
+				sensesSequenceItem.Senses = append(sensesSequenceItem.Senses, sense)
+			case name == "pseq":
 				var parenthesizedSenseSequence mWParenthesizedSenseSequence
-				err = json.Unmarshal(*itemParts[1], &parenthesizedSenseSequence)
-				if err == nil {
-					sensesSequenceItem.ParenthesizedSenseSequences = append(sensesSequenceItem.ParenthesizedSenseSequences, parenthesizedSenseSequence)
+				err := json.Unmarshal(value, &parenthesizedSenseSequence)
+				if err != nil {
+					return err
 				}
-			}
 
-			if err != nil {
-				return err
+				sensesSequenceItem.ParenthesizedSenseSequences = append(sensesSequenceItem.ParenthesizedSenseSequences, parenthesizedSenseSequence)
+			default:
+				return errUnknownMWElement
 			}
+
+			return nil
+		})
+
+		if err != nil {
+			reportMWDecodingIssue("sseq", err)
+			continue
 		}
 
 		s.Items = append(s.Items, sensesSequenceItem)
@@ -176,50 +301,30 @@ type mWParenthesizedSenseSequence struct {
 }
 
 func (s *mWParenthesizedSenseSequence) UnmarshalJSON(data []byte) error {
-	var sections []*json.RawMessage
-	err := json.Unmarshal(data, &sections)
-	if err != nil {
-		return err
-	}
-
-	for _, section := range sections {
-		var subsections []*json.RawMessage
-		err = json.Unmarshal(*section, &subsections)
-		if err != nil {
-			return err
-		}
-
-		if len(subsections) != 2 {
-			continue
-		}
-
-		var subsecionName string
-		err = json.Unmarshal(*subsections[0], &subsecionName)
-		if err != nil {
-			return err
-		}
-
+	return forEachMWElement(data, "pseq", func(name string, value json.RawMessage) error {
 		switch {
-		case subsecionName == "sense":
+		case name == "sense":
 			var sense mWSense
-			err = json.Unmarshal(*subsections[1], &sense)
-			if err == nil {
-				s.Senses = append(s.Senses, sense)
+			err := json.Unmarshal(value, &sense)
+			if err != nil {
+				return err
 			}
-		case subsecionName == "bs":
+
+			s.Senses = append(s.Senses, sense)
+		case name == "bs":
 			var bindingSubstitute mWBindingSubstitution
-			err = json.Unmarshal(*subsections[1], &bindingSubstitute)
-			if err == nil {
-				s.BindingSubstitution = &bindingSubstitute
+			err := json.Unmarshal(value, &bindingSubstitute)
+			if err != nil {
+				return err
 			}
-		}
 
-		if err != nil {
-			return err
+			s.BindingSubstitution = &bindingSubstitute
+		default:
+			return errUnknownMWElement
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
 type mWBindingSubstitution struct {
@@ -230,7 +335,25 @@ type mWSense struct {
 	SenseOrder        string              `json:"sn"`
 	DefiningText      mWDefiningText      `json:"dt"`
 	SenseStatusLabels mWSenseStatusLabels `json:"sls"`
-	DividedSense      *mWDividedSense     `json:"sdsense"`
+	GeneralLabels     []string            `json:"lbs"`
+	GrammaticalNote   string              `json:"sgram"`
+	// Learner's grammatical note on the form of the headword used in the sense
+	WordGrammaticalNote string          `json:"wsgram"`
+	DividedSense        *mWDividedSense `json:"sdsense"`
+	// Thesaurus word lists, every list is made of groups of words
+	SynonymLists  [][]mWThesaurusWord `json:"syn_list"`
+	SimilarLists  [][]mWThesaurusWord `json:"sim_list"`
+	AntonymLists  [][]mWThesaurusWord `json:"ant_list"`
+	OppositeLists [][]mWThesaurusWord `json:"opp_list"`
+}
+
+type mWThesaurusWord struct {
+	Word string `json:"wd"`
+}
+
+func (s *mWSense) UnmarshalJSON(data []byte) error {
+	type plainMWSense mWSense
+	return unmarshalMWObject(data, "sense", (*plainMWSense)(s))
 }
 
 type mWDividedSense struct {
@@ -243,49 +366,109 @@ type mWDefiningText struct {
 	InfoNotes  *mWSupplimentalNote
 	UsageNotes []mWUsageNote
 	Examples   []mWVerbalIllustration
+	CalledAlso *mWCalledAlso
+	BoldNotes  []string
 }
 
 func (s *mWDefiningText) UnmarshalJSON(data []byte) error {
-	var contents []*json.RawMessage
-	err := json.Unmarshal(data, &contents)
-	if err != nil {
-		return err
-	}
+	return forEachMWElement(data, "dt", func(name string, value json.RawMessage) error {
+		switch {
+		case name == "text":
+			// Text may be split into several parts by other elements like run-ins
+			var text string
+			err := json.Unmarshal(value, &text)
+			if err != nil {
+				return err
+			}
 
-	for _, content := range contents {
-		var subcontents []*json.RawMessage
-		err := json.Unmarshal(*content, &subcontents)
-		if err != nil {
-			return err
-		}
+			s.Text += text
+		case name == "snote":
+			return json.Unmarshal(value, &s.InfoNotes)
+		case name == "uns":
+			var usageNotes []mWUsageNote
+			err := json.Unmarshal(value, &usageNotes)
+			if err != nil {
+				return err
+			}
 
-		if len(subcontents) != 2 {
-			return nil
-		}
+			s.UsageNotes = append(s.UsageNotes, usageNotes...)
+		case name == "vis":
+			var examples []mWVerbalIllustration
+			err := json.Unmarshal(value, &examples)
+			if err != nil {
+				return err
+			}
 
-		var subcontentName string
-		err = json.Unmarshal(*subcontents[0], &subcontentName)
-		if err != nil {
-			return err
+			s.Examples = append(s.Examples, examples...)
+		case name == "ca":
+			return json.Unmarshal(value, &s.CalledAlso)
+		case name == "ri":
+			// Run-ins are kept in place within the text
+			var runIn mWRunIn
+			err := json.Unmarshal(value, &runIn)
+			if err != nil {
+				return err
+			}
+
+			s.Text += strings.Join(runIn.Parts, "")
+		case name == "bnote":
+			var boldNote string
+			err := json.Unmarshal(value, &boldNote)
+			if err != nil {
+				return err
+			}
+
+			s.BoldNotes = append(s.BoldNotes, boldNote)
+		default:
+			return errUnknownMWElement
 		}
 
+		return nil
+	})
+}
+
+type mWCalledAlso struct {
+	Intro   string             `json:"intro"`
+	Targets []mWCalledAlsoItem `json:"cats"`
+}
+
+type mWCalledAlsoItem struct {
+	Text string `json:"cat"`
+}
+
+// Run-in is a sequence of run-in words interleaved with text
+type mWRunIn struct {
+	Parts []string
+}
+
+func (s *mWRunIn) UnmarshalJSON(data []byte) error {
+	return forEachMWElement(data, "ri", func(name string, value json.RawMessage) error {
 		switch {
-		case subcontentName == "text":
-			err = json.Unmarshal(*subcontents[1], &s.Text)
-		case subcontentName == "snote":
-			err = json.Unmarshal(*subcontents[1], &s.InfoNotes)
-		case subcontentName == "uns":
-			err = json.Unmarshal(*subcontents[1], &s.UsageNotes)
-		case subcontentName == "vis":
-			err = json.Unmarshal(*subcontents[1], &s.Examples)
-		}
+		case name == "riw":
+			var runInWord struct {
+				Text string `json:"rie"`
+			}
 
-		if err != nil {
-			return err
+			err := json.Unmarshal(value, &runInWord)
+			if err != nil {
+				return err
+			}
+
+			s.Parts = append(s.Parts, fmt.Sprintf("{b}%s{/b}", runInWord.Text))
+		case name == "text":
+			var text string
+			err := json.Unmarshal(value, &text)
+			if err != nil {
+				return err
+			}
+
+			s.Parts = append(s.Parts, text)
+		default:
+			return errUnknownMWElement
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
 type mWSupplimentalNote struct {
@@ -294,42 +477,24 @@ type mWSupplimentalNote struct {
 }
 
 func (s *mWSupplimentalNote) UnmarshalJSON(data []byte) error {
-	var contents []*json.RawMessage
-	err := json.Unmarshal(data, &contents)
-	if err != nil {
-		return err
-	}
-
-	for _, content := range contents {
-		var subcontents []*json.RawMessage
-		err := json.Unmarshal(*content, &subcontents)
-		if err != nil {
-			return err
-		}
-
-		if len(subcontents) != 2 {
-			return nil
-		}
-
-		var subcontentName string
-		err = json.Unmarshal(*subcontents[0], &subcontentName)
-		if err != nil {
-			return err
-		}
-
+	return forEachMWElement(data, "snote", func(name string, value json.RawMessage) error {
 		switch {
-		case subcontentName == "t":
-			err = json.Unmarshal(*subcontents[1], &s.Text)
-		case subcontentName == "vis":
-			err = json.Unmarshal(*subcontents[1], &s.Examples)
-		}
+		case name == "t":
+			return json.Unmarshal(value, &s.Text)
+		case name == "vis":
+			var examples []mWVerbalIllustration
+			err := json.Unmarshal(value, &examples)
+			if err != nil {
+				return err
+			}
 
-		if err != nil {
-			return err
+			s.Examples = append(s.Examples, examples...)
+		default:
+			return errUnknownMWElement
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
 type mWUsageNote struct {
@@ -338,50 +503,83 @@ type mWUsageNote struct {
 }
 
 func (s *mWUsageNote) UnmarshalJSON(data []byte) error {
-	var contents []*json.RawMessage
-	err := json.Unmarshal(data, &contents)
-	if err != nil {
-		return err
-	}
+	return forEachMWElement(data, "uns", func(name string, value json.RawMessage) error {
+		switch {
+		case name == "text":
+			return json.Unmarshal(value, &s.Text)
+		case name == "vis":
+			var examples []mWVerbalIllustration
+			err := json.Unmarshal(value, &examples)
+			if err != nil {
+				return err
+			}
 
-	for _, content := range contents {
-		var subcontents []*json.RawMessage
-		err := json.Unmarshal(*content, &subcontents)
-		if err != nil {
-			return err
+			s.Examples = append(s.Examples, examples...)
+		default:
+			return errUnknownMWElement
 		}
 
-		if len(subcontents) != 2 {
-			return nil
-		}
+		return nil
+	})
+}
 
-		var subcontentName string
-		err = json.Unmarshal(*subcontents[0], &subcontentName)
-		if err != nil {
-			return err
-		}
+type mWVerbalIllustration struct {
+	Text string `json:"t"`
+}
+
+type mWEtymology struct {
+	Texts []string
+	Notes []string
+}
 
+func (s *mWEtymology) UnmarshalJSON(data []byte) error {
+	return forEachMWElement(data, "et", func(name string, value json.RawMessage) error {
 		switch {
-		case subcontentName == "text":
-			err = json.Unmarshal(*subcontents[1], &s.Text)
-		case subcontentName == "vis":
-			var examples []mWVerbalIllustration
-			err = json.Unmarshal(*subcontents[1], &examples)
-			if err == nil {
-				s.Examples = append(s.Examples, examples...)
+		case name == "text":
+			var text string
+			err := json.Unmarshal(value, &text)
+			if err != nil {
+				return err
 			}
-		}
 
-		if err != nil {
-			return err
+			s.Texts = append(s.Texts, text)
+		case name == "et_snote":
+			var notes [][]string
+			err := json.Unmarshal(value, &notes)
+			if err != nil {
+				return err
+			}
+
+			for _, note := range notes {
+				// Supplemental note consists of ["t", text] pairs
+				if len(note) == 2 && note[0] == "t" {
+					s.Notes = append(s.Notes, note[1])
+				}
+			}
+		default:
+			return errUnknownMWElement
 		}
-	}
 
-	return nil
+		return nil
+	})
 }
 
-type mWVerbalIllustration struct {
-	Text string `json:"t"`
+type mWSynonymsParagraph struct {
+	Label string         `json:"pl"`
+	Text  mWDefiningText `json:"pt"`
+	// Words whose entries contain the same synonyms discussion
+	SeeAlso []string `json:"sarefs"`
+}
+
+type mWQuote struct {
+	Text        string        `json:"t"`
+	Attribution mWAttribution `json:"aq"`
+}
+
+type mWAttribution struct {
+	Author string `json:"auth"`
+	Source string `json:"source"`
+	Date   string `json:"aqdate"`
 }
 
 func getSubdirectoryForAudio(fileName string) string {
@@ -405,317 +603,428 @@ func getSubdirectoryForAudio(fileName string) string {
 	return fileName[0:1]
 }
 
-func getDefinitionFromMWDictionary(item string) (*mWDictionaryResponse, error) {
-	if mWApiToken == "" {
-		mWApiToken = os.Getenv("MW_DICTIONARY_API_TOKEN")
-		if mWApiToken == "" {
-			log.Fatalf("Environment variable for Merriam Webster Dictionary is not set")
-			return nil, fmt.Errorf("Empty Merriam Webster Dictionary API token")
-		}
+type mWDictionaryProvider struct {
+	reference mWReference
+	apiToken  string
+}
+
+func newMWDictionaryProvider() (dictionaryProvider, error) {
+	return newMWReferenceProvider(mWCollegiateReference)
+}
+
+func newMWLearnersProvider() (dictionaryProvider, error) {
+	return newMWReferenceProvider(mWLearnersReference)
+}
+
+func newMWThesaurusProvider() (dictionaryProvider, error) {
+	provider, err := newMWReferenceProvider(mWThesaurusReference)
+	if err != nil {
+		return nil, err
 	}
 
-	item = strings.ToLower(item)
-	requestUrl := fmt.Sprintf(mWRequestFormat, url.PathEscape(item), mWApiToken)
-	request, _ := http.NewRequest("GET", requestUrl, nil)
+	return &mWThesaurusProvider{mWDictionaryProvider: provider}, nil
+}
 
-	contents, err := processRequest(request)
+func newMWReferenceProvider(reference mWReference) (*mWDictionaryProvider, error) {
+	apiToken := os.Getenv(reference.tokenVariable)
+	if apiToken == "" {
+		return nil, errProviderNotConfigured
+	}
+
+	return &mWDictionaryProvider{
+		reference: reference,
+		apiToken:  apiToken,
+	}, nil
+}
+
+func (provider *mWDictionaryProvider) name() string {
+	return provider.reference.providerName
+}
+
+func (provider *mWDictionaryProvider) fetch(ctx context.Context, item string) ([]byte, error) {
+	return getDefinitionFromMWDictionary(ctx, provider.reference, provider.apiToken, item)
+}
+
+func (provider *mWDictionaryProvider) decode(contents []byte) (*dictionaryResponse, error) {
+	var mWResponse mWDictionaryResponse
+	err := json.Unmarshal(contents, &mWResponse)
 	if err != nil {
-		fmt.Printf("Failed getting meanings from Merriam Webster Dictionary for '%s'", item)
 		return nil, err
+	} else if len(mWResponse.Entries) == 0 && len(mWResponse.Suggestions) == 0 {
+		return nil, errNothingFound
 	}
 
-	var response mWDictionaryResponse
-	err = json.Unmarshal(contents, &response.Entries)
+	return convertMWDictionaryResponse(&mWResponse), nil
+}
+
+func getDefinitionFromMWDictionary(ctx context.Context, reference mWReference, apiToken string, item string) ([]byte, error) {
+	item = strings.ToLower(item)
+	requestUrl := fmt.Sprintf(mWRequestFormat, reference.name, url.PathEscape(item), apiToken)
+	request, _ := http.NewRequest("GET", requestUrl, nil)
+
+	contents, err := providerClient.do(ctx, request)
 	if err != nil {
-		fmt.Printf("Failed response deserialization from Merriam Webster Dictionary for '%s'", item)
+		log.Printf("Failed getting meanings from Merriam Webster %s reference for '%s'. %s", reference.name, item, err)
 		return nil, err
 	}
 
-	return &response, nil
+	return contents, nil
 }
 
-func convertMWDictionaryResponse(mWResponse *mWDictionaryResponse) *responseContent {
-	var builder responseBuilder
+func convertMWDictionaryResponse(mWResponse *mWDictionaryResponse) *dictionaryResponse {
+	var response dictionaryResponse
+
+	response.suggestions = mWResponse.Suggestions
 
-	isFirst := true
 	for _, mWEntry := range mWResponse.Entries {
-		if isFirst {
-			isFirst = false
-		} else {
-			builder.append("\n")
-		}
+		var entry dictionaryEntry
 
 		headword := strings.ReplaceAll(mWEntry.HeadwordInfo.Headword, "*", "·")
-		builder.append(fmt.Sprintf("🔲 <code>%s</code>", headword))
-		if mWEntry.PartOfSpeech != "" {
-			builder.append(fmt.Sprintf(" <i>%s</i>\n", mWEntry.PartOfSpeech))
-		} else {
-			builder.append("\n")
+		entry.id = mWEntry.Meta.EntryID
+		entry.item = headword
+		entry.pronunciations = convertMWPronunciations(mWEntry.HeadwordInfo.Pronunciations)
+
+		lexeme := entryLexeme{
+			lemma:           headword,
+			partOfSpeech:    mWEntry.PartOfSpeech,
+			grammaticalNote: mWStringToPlainText(mWEntry.GrammaticalNote),
+			labels:          mWEntry.GeneralLabels,
+			inflections:     convertMWInflections(mWEntry.Inflections),
+			crossReferences: convertMWCognateCrossReferences(mWEntry.CognateCrossReferences),
 		}
 
-		pronunciations := formatMWPronunciations(mWEntry.HeadwordInfo.Pronunciations)
-		if pronunciations != "" {
-			builder.append(pronunciations)
-			builder.append("\n")
+		lexeme.senses = convertMWDefinitionSections(mWEntry.DefinitionSections)
+		entry.lexemes = append(entry.lexemes, lexeme)
+
+		for _, definedRunOn := range mWEntry.DefinedRunOns {
+			entry.lexemes = append(entry.lexemes, entryLexeme{
+				lemma:           mWStringToPlainText(definedRunOn.Phrase),
+				partOfSpeech:    definedRunOn.PartOfSpeech,
+				grammaticalNote: joinMWGrammaticalNotes(definedRunOn.GrammaticalNote, definedRunOn.WordGrammaticalNote),
+				labels:          definedRunOn.GeneralLabels,
+				senses:          convertMWDefinitionSections(definedRunOn.DefinitionSections),
+				runOn:           true,
+			})
 		}
 
-		for _, defenitionSection := range mWEntry.DefinitionSections {
-			if defenitionSection.VerbDivider != "" {
-				builder.append(fmt.Sprintf("[<i>%s</i>]\n", defenitionSection.VerbDivider))
-			}
+		for _, undefinedRunOn := range mWEntry.UndefinedRunOns {
+			entry.lexemes = append(entry.lexemes, convertMWUndefinedRunOn(headword, undefinedRunOn))
+		}
 
-			for _, senseSection := range defenitionSection.SenseSequence.Items {
-				if senseSection.BindingSubstitution != nil {
-					builder.append(formatMWSense("◽️", senseSection.BindingSubstitution.Sense))
-					builder.append("\n")
-				}
+		entry.sections = convertMWEntrySections(mWEntry)
+		response.entries = append(response.entries, entry)
+	}
+
+	return &response
+}
 
-				for _, parenthesizedSenseSeqense := range senseSection.ParenthesizedSenseSequences {
-					requiresParenthesis := false
-					if parenthesizedSenseSeqense.BindingSubstitution != nil {
-						builder.append(formatMWSense("◽️", parenthesizedSenseSeqense.BindingSubstitution.Sense))
-						builder.append("\n")
+func convertMWDefinitionSections(definitionSections []mWDefinitionsSection) []lexemeSense {
+	var senses []lexemeSense
 
-						requiresParenthesis = true
-					}
+	for _, definitionSection := range definitionSections {
+		sectionStart := len(senses)
 
-					for idx, sense := range parenthesizedSenseSeqense.Senses {
-						var marker string
-						if requiresParenthesis {
-							marker = fmt.Sprintf("▪(%d) ", idx+1)
-						} else {
-							marker = "▪"
-						}
+		for _, senseSection := range definitionSection.SenseSequence.Items {
+			if senseSection.TruncatedSense != nil {
+				senses = append(senses, convertMWSense(senseKindGeneral, "", *senseSection.TruncatedSense)...)
+			}
 
-						builder.append(formatMWSense(marker, sense))
-						builder.append("\n")
-					}
+			if senseSection.BindingSubstitution != nil {
+				senses = append(senses, convertMWSense(senseKindGeneral, "", senseSection.BindingSubstitution.Sense)...)
+			}
+
+			for _, parenthesizedSenseSeqense := range senseSection.ParenthesizedSenseSequences {
+				requiresParenthesis := false
+				if parenthesizedSenseSeqense.BindingSubstitution != nil {
+					senses = append(senses, convertMWSense(senseKindGeneral, "", parenthesizedSenseSeqense.BindingSubstitution.Sense)...)
+					requiresParenthesis = true
 				}
 
-				for _, sense := range senseSection.Senses {
-					builder.append(formatMWSense("▪️", sense))
-					builder.append("\n")
+				for idx, sense := range parenthesizedSenseSeqense.Senses {
+					number := ""
+					if requiresParenthesis {
+						number = strconv.Itoa(idx + 1)
+					}
+
+					senses = append(senses, convertMWSense(senseKindSubsense, number, sense)...)
 				}
 			}
+
+			for _, sense := range senseSection.Senses {
+				senses = append(senses, convertMWSense(senseKindRegular, "", sense)...)
+			}
+		}
+
+		if sectionStart < len(senses) {
+			senses[sectionStart].divider = definitionSection.VerbDivider
+			senses[sectionStart].dividerLabels = definitionSection.SubjectLabels.Labels
 		}
 	}
 
-	responseContent := builder.finish()
-	responseContent.content = processMWString(responseContent.content)
+	return senses
+}
+
+func convertMWUndefinedRunOn(headword string, undefinedRunOn mWUndefinedRunOn) entryLexeme {
+	lexeme := entryLexeme{
+		lemma:          strings.ReplaceAll(undefinedRunOn.Word, "*", "·"),
+		partOfSpeech:   undefinedRunOn.PartOfSpeech,
+		pronunciations: convertMWPronunciations(undefinedRunOn.Pronunciations),
+		labels:         undefinedRunOn.GeneralLabels,
+		runOn:          true,
+	}
 
-	return responseContent
+	// Undefined run-ons are derived forms explained by their parent entry
+	itemData := convertMWDefiningText(undefinedRunOn.Text)
+	if itemData.Definition == "" {
+		itemData.Definition = fmt.Sprintf("derived from <code>%s</code>", escapeHTML(headword))
+	}
+
+	lexeme.senses = append(lexeme.senses, lexemeSense{data: itemData})
+	return lexeme
 }
 
-func formatMWPronunciations(pronunciations []mWPronunciation) string {
-	var sb strings.Builder
+func convertMWEntrySections(mWEntry mWEntry) []entrySection {
+	var sections []entrySection
 
-	isFirst := true
-	for _, mWPronunciation := range pronunciations {
-		if mWPronunciation.Audio.FileName == "" && mWPronunciation.Transcription == "" {
-			continue
+	if len(mWEntry.Etymology.Texts) > 0 || len(mWEntry.Etymology.Notes) > 0 {
+		texts := append(append([]string{}, mWEntry.Etymology.Texts...), mWEntry.Etymology.Notes...)
+		sections = append(sections, entrySection{
+			title: "Etymology",
+			text:  processMWString(strings.Join(texts, "\n")),
+		})
+	}
+
+	if mWEntry.FirstKnownUse != "" {
+		sections = append(sections, entrySection{
+			title: "First Known Use",
+			text:  processMWString(mWEntry.FirstKnownUse),
+		})
+	}
+
+	for _, synonymsParagraph := range mWEntry.SynonymsParagraphs {
+		itemData := convertMWDefiningText(synonymsParagraph.Text)
+		section := entrySection{
+			title:    mWStringToPlainText(synonymsParagraph.Label),
+			text:     itemData.Definition,
+			examples: itemData.Examples,
 		}
 
-		if isFirst {
-			sb.WriteRune('\\')
+		if len(synonymsParagraph.SeeAlso) > 0 {
+			section.text += fmt.Sprintf("\n<i>see also</i> %s", escapeHTML(strings.Join(synonymsParagraph.SeeAlso, ", ")))
 		}
 
-		audioUrl := ""
-		if mWPronunciation.Audio.FileName != "" {
-			audioUrl = fmt.Sprintf(mWAudioLinkFormat, getSubdirectoryForAudio(mWPronunciation.Audio.FileName), mWPronunciation.Audio.FileName)
+		sections = append(sections, section)
+	}
+
+	if len(mWEntry.Quotes) > 0 {
+		section := entrySection{title: "Quotes"}
+		for _, quote := range mWEntry.Quotes {
+			section.examples = append(section.examples, formatMWQuote(quote))
 		}
 
-		if mWPronunciation.Transcription != "" {
-			if audioUrl == "" {
-				sb.WriteString(mWPronunciation.Transcription)
-			} else {
-				sb.WriteString(fmt.Sprintf("<a href=\"%s\">%s 🎧</a>", audioUrl, mWPronunciation.Transcription))
+		sections = append(sections, section)
+	}
+
+	return sections
+}
+
+func formatMWQuote(quote mWQuote) string {
+	var attribution []string
+	for _, part := range []string{quote.Attribution.Author, quote.Attribution.Source, quote.Attribution.Date} {
+		if part != "" {
+			attribution = append(attribution, part)
+		}
+	}
+
+	text := processMWString(quote.Text)
+	if len(attribution) == 0 {
+		return text
+	}
+
+	return fmt.Sprintf("%s — <i>%s</i>", text, processMWString(strings.Join(attribution, ", ")))
+}
+
+func convertMWCognateCrossReferences(mWCrossReferences []mWCognateCrossReference) []lexemeCrossReference {
+	var crossReferences []lexemeCrossReference
+
+	for _, mWCrossReference := range mWCrossReferences {
+		crossReference := lexemeCrossReference{label: mWCrossReference.Label}
+
+		for _, target := range mWCrossReference.Targets {
+			// Target may contain a homograph number like "run:1"
+			text := mWStringToPlainText(strings.SplitN(target.Text, ":", 2)[0])
+			if text == "" {
+				continue
 			}
-		} else {
-			sb.WriteString(fmt.Sprintf("<a href=\"%s\">🎧</a>", audioUrl))
+
+			crossReference.targets = append(crossReference.targets, lexemeCrossReferenceTarget{
+				word:        text,
+				senseNumber: target.SenseNumber,
+			})
 		}
 
-		sb.WriteRune('\\')
-		isFirst = false
+		crossReferences = append(crossReferences, crossReference)
 	}
 
-	return sb.String()
+	return crossReferences
 }
 
-func formatMWSense(marker string, sense mWSense) string {
-	var sb strings.Builder
+func convertMWInflections(mWInflections []mWInflection) []lexemeInflection {
+	var inflections []lexemeInflection
+
+	for _, mWInflection := range mWInflections {
+		form := mWInflection.Inflection
+		if form == "" {
+			form = mWInflection.InflectionCutback
+		}
 
-	sb.WriteString(marker)
-	sb.WriteString(formatMWDefiningText(sense.DefiningText))
+		if form == "" && mWInflection.Label == "" {
+			continue
+		}
 
-	if sense.DividedSense != nil {
-		definingText := formatMWDefiningText(sense.DividedSense.DefinitionText)
-		sb.WriteString(fmt.Sprintf("\n<i>%s</i>%s", sense.DividedSense.SenseDivider, definingText))
+		inflections = append(inflections, lexemeInflection{
+			label: mWInflection.Label,
+			form:  strings.ReplaceAll(form, "*", "·"),
+		})
 	}
 
-	return sb.String()
+	return inflections
 }
 
-func formatMWDefiningText(definingText mWDefiningText) string {
+func convertMWPronunciations(mWPronunciations []mWPronunciation) []entryPronunciation {
+	var pronunciations []entryPronunciation
+
+	for _, mWPronunciation := range mWPronunciations {
+		var pronunciation entryPronunciation
+
+		if mWPronunciation.Transcription != "" {
+			pronunciation.transcriptions = append(pronunciation.transcriptions, mWPronunciation.Transcription)
+		}
+
+		if mWPronunciation.PreLabel != "" {
+			pronunciation.regions = append(pronunciation.regions, mWPronunciation.PreLabel)
+		}
+
+		if mWPronunciation.Audio.FileName != "" {
+			pronunciation.audioUrl = fmt.Sprintf(mWAudioLinkFormat, getSubdirectoryForAudio(mWPronunciation.Audio.FileName), mWPronunciation.Audio.FileName)
+		}
+
+		pronunciations = append(pronunciations, pronunciation)
+	}
+
+	return pronunciations
+}
+
+func convertMWSense(kind senseKind, number string, mWSense mWSense) []lexemeSense {
+	data := convertMWDefiningText(mWSense.DefiningText)
+	data.Synonyms = convertMWThesaurusLists(mWSense.SynonymLists, mWSense.SimilarLists)
+	data.Antonyms = convertMWThesaurusLists(mWSense.AntonymLists, mWSense.OppositeLists)
+
+	// General labels (e.g. "often capitalized") go before status labels (e.g. "British")
+	labels := append(append([]string{}, mWSense.GeneralLabels...), mWSense.SenseStatusLabels.Labels...)
+
+	senses := []lexemeSense{{
+		kind:            kind,
+		number:          number,
+		labels:          labels,
+		grammaticalNote: joinMWGrammaticalNotes(mWSense.GrammaticalNote, mWSense.WordGrammaticalNote),
+		data:            data,
+	}}
+
+	if mWSense.DividedSense != nil {
+		senses = append(senses, lexemeSense{
+			kind:  senseKindDivided,
+			label: mWSense.DividedSense.SenseDivider,
+			data:  convertMWDefiningText(mWSense.DividedSense.DefinitionText),
+		})
+	}
+
+	return senses
+}
+
+func joinMWGrammaticalNotes(notes ...string) string {
+	var parts []string
+	for _, note := range notes {
+		if note != "" {
+			parts = append(parts, mWStringToPlainText(note))
+		}
+	}
+
+	return strings.Join(parts, "; ")
+}
+
+// convertMWThesaurusLists makes a list of unique words out of thesaurus
+// lists, primary lists go first
+func convertMWThesaurusLists(lists ...[][]mWThesaurusWord) []string {
+	var words []string
+	added := map[string]bool{}
+
+	for _, list := range lists {
+		for _, group := range list {
+			for _, word := range group {
+				text := mWStringToPlainText(word.Word)
+				if text == "" || added[text] || len(words) == maxMWThesaurusWords {
+					continue
+				}
+
+				added[text] = true
+				words = append(words, text)
+			}
+		}
+	}
+
+	return words
+}
+
+func convertMWDefiningText(definingText mWDefiningText) dictionaryItemData {
 	var sb strings.Builder
+	var itemData dictionaryItemData
+
 	sb.WriteString(definingText.Text)
 
+	for _, boldNote := range definingText.BoldNotes {
+		sb.WriteString(fmt.Sprintf(" {b}{it}%s{/it}{/b}", boldNote))
+	}
+
+	if definingText.CalledAlso != nil && len(definingText.CalledAlso.Targets) > 0 {
+		var targets []string
+		for _, target := range definingText.CalledAlso.Targets {
+			targets = append(targets, fmt.Sprintf("{b}%s{/b}", target.Text))
+		}
+
+		intro := definingText.CalledAlso.Intro
+		if intro == "" {
+			intro = "called also"
+		}
+
+		sb.WriteString(fmt.Sprintf(" — {it}%s{/it} %s", intro, strings.Join(targets, ", ")))
+	}
+
 	for _, usageNote := range definingText.UsageNotes {
 		if usageNote.Text != "" {
 			sb.WriteString(fmt.Sprintf("— %s", usageNote.Text))
 		}
 
-		for _, usageNoteExample := range usageNote.Examples {
-			if usageNoteExample.Text != "" {
-				sb.WriteString(fmt.Sprintf("\n// %s", usageNoteExample.Text))
-			}
-		}
+		itemData.Examples = appendMWExamples(itemData.Examples, usageNote.Examples)
 	}
 
 	if definingText.InfoNotes != nil {
 		sb.WriteString(fmt.Sprintf("— %s", definingText.InfoNotes.Text))
-		for _, example := range definingText.InfoNotes.Examples {
-			sb.WriteString(fmt.Sprintf("\n// %s", example.Text))
-		}
+		itemData.Examples = appendMWExamples(itemData.Examples, definingText.InfoNotes.Examples)
 	}
 
-	for _, example := range definingText.Examples {
+	itemData.Examples = appendMWExamples(itemData.Examples, definingText.Examples)
+	itemData.Definition = processMWString(sb.String())
+
+	return itemData
+}
+
+func appendMWExamples(examples []string, mWExamples []mWVerbalIllustration) []string {
+	for _, example := range mWExamples {
 		if example.Text != "" {
-			sb.WriteString(fmt.Sprintf("\n// %s", example.Text))
+			examples = append(examples, processMWString(example.Text))
 		}
 	}
 
-	return sb.String()
-}
-
-func processMWString(mWString string) string {
-	mWString = strings.ReplaceAll(mWString, "{b}", "<b>")        // display text in bold (opening)
-	mWString = strings.ReplaceAll(mWString, "{/b}", "</b>")      // display text in bold (closing)
-	mWString = strings.ReplaceAll(mWString, "{bc}", "<b>: </b>") // output a bold colon and a space
-	mWString = strings.ReplaceAll(mWString, "{it}", "<i>")       // display text in italics (opening)
-	mWString = strings.ReplaceAll(mWString, "{/it}", "</i>")     // display text in italics (closing)
-	mWString = strings.ReplaceAll(mWString, "{ldquo}", "“")      // output a left double quote character (U+201C: “)
-	mWString = strings.ReplaceAll(mWString, "{rdquo}", "”")      // output a right double quote character (U+201D: ”)
-	mWString = strings.ReplaceAll(mWString, "{sc}", "")          // display text in small capitals (opening)
-	mWString = strings.ReplaceAll(mWString, "{/sc}", "")         // display text in small capitals (closing)
-	mWString = strings.ReplaceAll(mWString, "{inf}", " [^")      // display text in subscript (opening)
-	mWString = strings.ReplaceAll(mWString, "{/inf}", "] ")      // display text in subscript (closing)
-	mWString = strings.ReplaceAll(mWString, "{sup}", " [_")      // display text in superscript (opening)
-	mWString = strings.ReplaceAll(mWString, "{/sup}", "] ")      // display text in superscript (closing)
-	mWString = strings.ReplaceAll(mWString, "{p_br}", "\n")      // indicates where a paragraph break should be inserted
-
-	mWString = strings.ReplaceAll(mWString, "{gloss}", "[")          // encloses a gloss explaining how a word or phrase is used in a particular context (opening)
-	mWString = strings.ReplaceAll(mWString, "{/gloss}", "]")         // encloses a gloss explaining how a word or phrase is used in a particular context (closing)
-	mWString = strings.ReplaceAll(mWString, "{parahw}", "<b>")       // encloses an instance of the headword within a paragraph label (opening)
-	mWString = strings.ReplaceAll(mWString, "{/qword}", "</b>")      // encloses an instance of the headword within a paragraph label (closing)
-	mWString = strings.ReplaceAll(mWString, "{phrase}", "<b><i>")    // encloses a phrase in running text (this may be a phrase containing the headword or a defined run-on phrase) (opening)
-	mWString = strings.ReplaceAll(mWString, "{/phrase}", "</i></b>") // encloses a phrase in running text (this may be a phrase containing the headword or a defined run-on phrase) (closing)
-	mWString = strings.ReplaceAll(mWString, "{qword}", "<i>\"")      // encloses an instance of the headword within a quote (opening)
-	mWString = strings.ReplaceAll(mWString, "{/qword}", "\"</i>")    // encloses an instance of the headword within a quote (closing)
-	mWString = strings.ReplaceAll(mWString, "{wi}", "<b><i>")        // encloses an instance of the headword used in running text (opening)
-	mWString = strings.ReplaceAll(mWString, "{/wi}", "</i></b>")     // encloses an instance of the headword used in running text (closing)
-
-	mWString = strings.ReplaceAll(mWString, "{dx}", "— ") // encloses introductory text and one or more {dxt} cross-reference tokens (opening)
-	mWString = strings.ReplaceAll(mWString, "{/dx}", "")  // encloses introductory text and one or more {dxt} cross-reference tokens (closing)
-
-	mWString = strings.ReplaceAll(mWString, "{dx_def}", "— ") // used for a parenthetical cross-reference; encloses introductory text and one or more {dxt} cross-reference tokens (opening)
-	mWString = strings.ReplaceAll(mWString, "{/dx_def}", "")  // used for a parenthetical cross-reference; encloses introductory text and one or more {dxt} cross-reference tokens (closing)
-
-	mWString = strings.ReplaceAll(mWString, "{dx_ety}", "— ") // used for a directional cross-reference within an etymology; encloses introductory text and one or more {dxt} cross-reference tokens (opening)
-	mWString = strings.ReplaceAll(mWString, "{/dx_ety}", "")  // used for a directional cross-reference within an etymology; encloses introductory text and one or more {dxt} cross-reference tokens (closing)
-
-	mWString = strings.ReplaceAll(mWString, "{ma}", "— <i>more at</i> ") // used for a "more at" informational cross-reference within an etymology; encloses introductory text and one or more {mat} tokens (opening)
-	mWString = strings.ReplaceAll(mWString, "{/ma}", "")                 // used for a "more at" informational cross-reference within an etymology; encloses introductory text and one or more {mat} tokens (closing)
-
-	index := strings.Index(mWString, "{a_link|")
-	for index >= 0 {
-		endIndex := strings.IndexRune(mWString[index:], '}') + index
-		aLinkData := mWString[index : endIndex+1]
-		data := aLinkData[len("{a_link|") : len(aLinkData)-1]
-		replacement := fmt.Sprintf("<code>%s</code>", data)
-		mWString = strings.ReplaceAll(mWString, aLinkData, replacement)
-
-		index = strings.Index(mWString, "{a_link|")
-	}
-
-	index = strings.Index(mWString, "{d_link|")
-	for index >= 0 {
-		endIndex := strings.IndexRune(mWString[index:], '}') + index
-		dLinkData := mWString[index : endIndex+1]
-		firstArgOffset := len("{d_link|") + 1
-		secondSplitterOffset := firstArgOffset + strings.IndexRune(dLinkData[firstArgOffset:], '|')
-		hyperlinkData := dLinkData[len("{d_link|"):secondSplitterOffset]
-		replacement := fmt.Sprintf("<code>%s</code>", hyperlinkData)
-		mWString = strings.ReplaceAll(mWString, dLinkData, replacement)
-		index = strings.Index(mWString, "{d_link|")
-	}
-
-	index = strings.Index(mWString, "{i_link|")
-	for index >= 0 {
-		endIndex := strings.IndexRune(mWString[index:], '}') + index
-		iLinkData := mWString[index : endIndex+1]
-		firstArgOffset := len("{i_link|") + 1
-		secondSplitterOffset := firstArgOffset + strings.IndexRune(iLinkData[firstArgOffset:], '|')
-		hyperlinkData := iLinkData[len("{i_link|"):secondSplitterOffset]
-		replacement := fmt.Sprintf("<code>%s</code>", hyperlinkData)
-		mWString = strings.ReplaceAll(mWString, iLinkData, replacement)
-		index = strings.Index(mWString, "{i_link|")
-	}
-
-	index = strings.Index(mWString, "{et_link|")
-	for index >= 0 {
-		endIndex := strings.IndexRune(mWString[index:], '}') + index
-		etLinkData := mWString[index : endIndex+1]
-		firstArgOffset := len("{et_link|") + 1
-		secondSplitterOffset := firstArgOffset + strings.IndexRune(etLinkData[firstArgOffset:], '|')
-		hyperlinkData := etLinkData[len("{et_link|"):secondSplitterOffset]
-		replacement := fmt.Sprintf("<code>%s</code>", hyperlinkData)
-		mWString = strings.ReplaceAll(mWString, etLinkData, replacement)
-		index = strings.Index(mWString, "{et_link|")
-	}
-
-	index = strings.Index(mWString, "{mat|")
-	for index >= 0 {
-		endIndex := strings.IndexRune(mWString[index:], '}') + index
-		matLinkData := mWString[index : endIndex+1]
-		firstArgOffset := len("{mat|") + 1
-		secondSplitterOffset := firstArgOffset + strings.IndexRune(matLinkData[firstArgOffset:], '|')
-		hyperlinkData := matLinkData[len("{mat|"):secondSplitterOffset]
-		replacement := fmt.Sprintf("<code>%s</code>", hyperlinkData)
-		mWString = strings.ReplaceAll(mWString, matLinkData, replacement)
-		index = strings.Index(mWString, "{mat|")
-	}
-
-	index = strings.Index(mWString, "{sx|")
-	for index >= 0 {
-		endIndex := strings.IndexRune(mWString[index:], '}') + index
-		sxLinkData := mWString[index : endIndex+1]
-		firstArgOffset := len("{sx|") + 1
-		secondSplitterOffset := firstArgOffset + strings.IndexRune(sxLinkData[firstArgOffset:], '|')
-		hyperlinkData := sxLinkData[len("{sx|"):secondSplitterOffset]
-		replacement := fmt.Sprintf("<code>%s</code>", hyperlinkData)
-		mWString = strings.ReplaceAll(mWString, sxLinkData, replacement)
-		index = strings.Index(mWString, "{sx|")
-	}
-
-	index = strings.Index(mWString, "{dxt|")
-	for index >= 0 {
-		endIndex := strings.IndexRune(mWString[index:], '}') + index
-		dxtLinkData := mWString[index : endIndex+1]
-		firstArgOffset := len("{dxt|") + 1
-		secondSplitterOffset := firstArgOffset + strings.IndexRune(dxtLinkData[firstArgOffset:], '|')
-		hyperlinkData := dxtLinkData[len("{dxt|"):secondSplitterOffset]
-		replacement := fmt.Sprintf("<code>%s</code>", hyperlinkData)
-		mWString = strings.ReplaceAll(mWString, dxtLinkData, replacement)
-		index = strings.Index(mWString, "{dxt|")
-	}
-
-	index = strings.Index(mWString, "{ds|")
-	for index >= 0 {
-		endIndex := strings.IndexRune(mWString[index:], '}') + index
-		dsData := mWString[index : endIndex+1]
-		mWString = strings.ReplaceAll(mWString, dsData, "")
-		index = strings.Index(mWString, "{ds|")
-	}
-
-	return mWString
+	return examples
 }
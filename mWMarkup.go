@@ -0,0 +1,265 @@
+package main
+
+import (
+	"strings"
+)
+
+// MW strings contain {token|arg|arg} markup. Tokens listed here enclose text
+// with an opening {token} and a closing {/token}, all the others stand alone
+var mWPairedTokens = map[string]bool{
+	"b":      true, // display text in bold
+	"it":     true, // display text in italics
+	"sc":     true, // display text in small capitals
+	"inf":    true, // display text in subscript
+	"sup":    true, // display text in superscript
+	"gloss":  true, // encloses a gloss explaining how a word or phrase is used in a particular context
+	"parahw": true, // encloses an instance of the headword within a paragraph label
+	"phrase": true, // encloses a phrase in running text
+	"qword":  true, // encloses an instance of the headword within a quote
+	"wi":     true, // encloses an instance of the headword used in running text
+	"dx":     true, // encloses introductory text and one or more {dxt} cross-reference tokens
+	"dx_def": true, // used for a parenthetical cross-reference
+	"dx_ety": true, // used for a directional cross-reference within an etymology
+	"ma":     true, // used for a "more at" informational cross-reference within an etymology
+}
+
+type mWMarkupTokenKind int
+
+const (
+	mWMarkupText mWMarkupTokenKind = iota
+	mWMarkupOpen
+	mWMarkupClose
+	mWMarkupSingle
+)
+
+type mWMarkupToken struct {
+	kind mWMarkupTokenKind
+	// Token name or text for text tokens
+	value string
+	args  []string
+}
+
+type mWMarkupNode struct {
+	// Empty for plain text nodes
+	name     string
+	text     string
+	args     []string
+	children []*mWMarkupNode
+}
+
+func tokenizeMWMarkup(mWString string) []mWMarkupToken {
+	var tokens []mWMarkupToken
+	var text strings.Builder
+
+	flushText := func() {
+		if text.Len() > 0 {
+			tokens = append(tokens, mWMarkupToken{kind: mWMarkupText, value: text.String()})
+			text.Reset()
+		}
+	}
+
+	for len(mWString) > 0 {
+		start := strings.IndexByte(mWString, '{')
+		if start < 0 {
+			text.WriteString(mWString)
+			break
+		}
+
+		text.WriteString(mWString[:start])
+		mWString = mWString[start:]
+
+		// Brace without a closing pair is not markup
+		end := strings.IndexAny(mWString[1:], "{}") + 1
+		if end <= 0 || mWString[end] != '}' {
+			text.WriteByte('{')
+			mWString = mWString[1:]
+			continue
+		}
+
+		content := mWString[1:end]
+		mWString = mWString[end+1:]
+
+		if content == "" {
+			text.WriteString("{}")
+			continue
+		}
+
+		flushText()
+
+		parts := strings.Split(content, "|")
+		name := parts[0]
+		switch {
+		case strings.HasPrefix(name, "/") && len(parts) == 1:
+			tokens = append(tokens, mWMarkupToken{kind: mWMarkupClose, value: name[1:]})
+		case mWPairedTokens[name] && len(parts) == 1:
+			tokens = append(tokens, mWMarkupToken{kind: mWMarkupOpen, value: name})
+		default:
+			tokens = append(tokens, mWMarkupToken{kind: mWMarkupSingle, value: name, args: parts[1:]})
+		}
+	}
+
+	flushText()
+	return tokens
+}
+
+// parseMWMarkup builds a tree of MW markup. Closing tokens without an opening
+// pair are dropped and tokens left open are closed at the end of the string
+func parseMWMarkup(mWString string) []*mWMarkupNode {
+	root := &mWMarkupNode{}
+	stack := []*mWMarkupNode{root}
+
+	for _, token := range tokenizeMWMarkup(mWString) {
+		top := stack[len(stack)-1]
+
+		switch token.kind {
+		case mWMarkupText:
+			top.children = append(top.children, &mWMarkupNode{text: token.value})
+		case mWMarkupSingle:
+			top.children = append(top.children, &mWMarkupNode{name: token.value, args: token.args})
+		case mWMarkupOpen:
+			node := &mWMarkupNode{name: token.value}
+			top.children = append(top.children, node)
+			stack = append(stack, node)
+		case mWMarkupClose:
+			for idx := len(stack) - 1; idx > 0; idx-- {
+				if stack[idx].name == token.value {
+					stack = stack[:idx]
+					break
+				}
+			}
+		}
+	}
+
+	return root.children
+}
+
+type mWMarkupStyle interface {
+	escape(text string) string
+	bold(content string) string
+	italic(content string) string
+	code(content string) string
+}
+
+type mWHTMLStyle struct{}
+
+func (mWHTMLStyle) escape(text string) string    { return escapeHTML(text) }
+func (mWHTMLStyle) bold(content string) string   { return "<b>" + content + "</b>" }
+func (mWHTMLStyle) italic(content string) string { return "<i>" + content + "</i>" }
+func (mWHTMLStyle) code(content string) string   { return "<code>" + content + "</code>" }
+
+// mWMarkdownStyle renders Telegram MarkdownV2, where every special character
+// of the text has to be escaped
+type mWMarkdownStyle struct{}
+
+var markdownEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"_", "\\_", "*", "\\*", "[", "\\[", "]", "\\]", "(", "\\(", ")", "\\)",
+	"~", "\\~", "`", "\\`", ">", "\\>", "#", "\\#", "+", "\\+", "-", "\\-",
+	"=", "\\=", "|", "\\|", "{", "\\{", "}", "\\}", ".", "\\.", "!", "\\!")
+
+func (mWMarkdownStyle) escape(text string) string    { return markdownEscaper.Replace(text) }
+func (mWMarkdownStyle) bold(content string) string   { return "*" + content + "*" }
+func (mWMarkdownStyle) italic(content string) string { return "_" + content + "_" }
+func (mWMarkdownStyle) code(content string) string   { return "`" + content + "`" }
+
+type mWPlainTextStyle struct{}
+
+func (mWPlainTextStyle) escape(text string) string    { return text }
+func (mWPlainTextStyle) bold(content string) string   { return content }
+func (mWPlainTextStyle) italic(content string) string { return content }
+func (mWPlainTextStyle) code(content string) string   { return content }
+
+func renderMWMarkup(nodes []*mWMarkupNode, style mWMarkupStyle) string {
+	var sb strings.Builder
+	for _, node := range nodes {
+		sb.WriteString(renderMWMarkupNode(node, style))
+	}
+
+	return sb.String()
+}
+
+func renderMWMarkupNode(node *mWMarkupNode, style mWMarkupStyle) string {
+	if node.name == "" {
+		return style.escape(node.text)
+	}
+
+	content := renderMWMarkup(node.children, style)
+
+	switch node.name {
+	case "b", "parahw":
+		return style.bold(content)
+	case "it":
+		return style.italic(content)
+	case "wi", "phrase":
+		return style.bold(style.italic(content))
+	case "qword":
+		return style.italic(style.escape("\"") + content + style.escape("\""))
+	case "sc":
+		return content
+	case "inf":
+		return style.escape("_") + content
+	case "sup":
+		return style.escape("^") + content
+	case "gloss":
+		return style.escape("[") + content + style.escape("]")
+	case "dx", "dx_def", "dx_ety":
+		return style.escape("— ") + content
+	case "ma":
+		return style.escape("— ") + style.italic(style.escape("more at")) + " " + content
+	case "bc":
+		return style.bold(style.escape(": "))
+	case "ldquo":
+		return "“"
+	case "rdquo":
+		return "”"
+	case "p_br":
+		return "\n"
+	case "ds":
+		// Dating sense is not displayed
+		return ""
+	case "a_link", "d_link", "i_link", "et_link", "mat", "sx", "dxt":
+		if len(node.args) == 0 || node.args[0] == "" {
+			return ""
+		}
+
+		return style.code(style.escape(mWLinkDisplayText(node.args[0])))
+	}
+
+	// Unknown tokens degrade to their contents or the first argument
+	if content != "" || len(node.args) == 0 {
+		return content
+	}
+
+	return style.escape(node.args[0])
+}
+
+// mWLinkDisplayText drops the homograph number of the linked entry ID
+// (e.g. "run:1"), which is not a part of the word
+func mWLinkDisplayText(target string) string {
+	idx := strings.LastIndexByte(target, ':')
+	if idx <= 0 || idx == len(target)-1 {
+		return target
+	}
+
+	for _, r := range target[idx+1:] {
+		if r < '0' || r > '9' {
+			return target
+		}
+	}
+
+	return target[:idx]
+}
+
+// processMWString converts MW markup into Telegram HTML
+func processMWString(mWString string) string {
+	return renderMWMarkup(parseMWMarkup(mWString), mWHTMLStyle{})
+}
+
+// mWStringToMarkdown converts MW markup into Telegram MarkdownV2
+func mWStringToMarkdown(mWString string) string {
+	return renderMWMarkup(parseMWMarkup(mWString), mWMarkdownStyle{})
+}
+
+func mWStringToPlainText(mWString string) string {
+	return renderMWMarkup(parseMWMarkup(mWString), mWPlainTextStyle{})
+}
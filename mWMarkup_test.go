@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestProcessMWString(t *testing.T) {
+	tests := []struct {
+		name     string
+		mWString string
+		want     string
+	}{
+		{"plain text", "a test", "a test"},
+		{"bold", "{b}bold{/b} text", "<b>bold</b> text"},
+		{"nested", "{it}an {b}important{/b} note{/it}", "<i>an <b>important</b> note</i>"},
+		{"headword in running text", "to {wi}test{/wi} it", "to <b><i>test</i></b> it"},
+		{"paragraph headword", "{parahw}run{/parahw} away", "<b>run</b> away"},
+		{"bold colon", "{bc}a means of testing", "<b>: </b>a means of testing"},
+		{"quotes", "{ldquo}yes{rdquo}", "“yes”"},
+		{"link", "see {sx|trial||}", "see <code>trial</code>"},
+		{"link homograph number", "{a_link|run:1}", "<code>run</code>"},
+		{"link without target", "{d_link||}", ""},
+		{"dated sense", "{ds||1||}sense", "sense"},
+		{"escaped text", "a < b & c", "a &lt; b &amp; c"},
+		{"escaped link", "{sx|a<b||}", "<code>a&lt;b</code>"},
+		{"unclosed brace", "a {b text", "a {b text"},
+		{"unclosed brace before token", "a {b {it}c{/it}", "a {b <i>c</i>"},
+		{"stray closing brace", "a } b", "a } b"},
+		{"empty braces", "a {} b", "a {} b"},
+		{"unclosed token", "{b}bold", "<b>bold</b>"},
+		{"closing without opening", "text{/b}", "text"},
+		{"closing without opening paragraph headword", "{/parahw}run", "run"},
+		{"interleaved tokens", "{b}a{it}b{/b}c{/it}", "<b>a<i>b</i></b>c"},
+		{"unknown token with contents", "{xyz|arg}", "arg"},
+		{"superscript", "x{sup}2{/sup}", "x^2"},
+		{"gloss", "{gloss}informal{/gloss}", "[informal]"},
+		{"more at", "{ma}{mat|run|}{/ma}", "— <i>more at</i> <code>run</code>"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := processMWString(test.mWString); got != test.want {
+				t.Errorf("processMWString(%q) = %q, want %q", test.mWString, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMWStringToMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		mWString string
+		want     string
+	}{
+		{"plain text", "a test", "a test"},
+		{"bold", "{b}bold{/b} text", "*bold* text"},
+		{"italic", "{it}italic{/it}", "_italic_"},
+		{"headword in running text", "to {wi}test{/wi} it", "to *_test_* it"},
+		{"bold colon", "{bc}a means", "*: *a means"},
+		{"link", "{a_link|run:1}", "`run`"},
+		{"escaped text", "a_b*c[d]e(f)g~h`i>j#k+l-m=n|o}p{q.r!s", "a\\_b\\*c\\[d\\]e\\(f\\)g\\~h\\`i\\>j\\#k\\+l\\-m\\=n\\|o\\}p\\{q\\.r\\!s"},
+		{"escaped backslash", "a\\b", "a\\\\b"},
+		{"escaped link", "{sx|x-ray||}", "`x\\-ray`"},
+		{"escaped gloss", "{gloss}often plural{/gloss}", "\\[often plural\\]"},
+		{"unclosed brace", "a {b text", "a \\{b text"},
+		{"unclosed token", "{it}italic", "_italic_"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mWStringToMarkdown(test.mWString); got != test.want {
+				t.Errorf("mWStringToMarkdown(%q) = %q, want %q", test.mWString, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMWStringToPlainText(t *testing.T) {
+	tests := []struct {
+		name     string
+		mWString string
+		want     string
+	}{
+		{"formatting dropped", "{b}bold{/b} and {it}italic{/it}", "bold and italic"},
+		{"text not escaped", "a < b", "a < b"},
+		{"link", "{a_link|run:2}", "run"},
+		{"unclosed paragraph headword", "{parahw}run", "run"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := mWStringToPlainText(test.mWString); got != test.want {
+				t.Errorf("mWStringToPlainText(%q) = %q, want %q", test.mWString, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMWLinkDisplayText(t *testing.T) {
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"run", "run"},
+		{"run:1", "run"},
+		{"run:12", "run"},
+		{"run:", "run:"},
+		{":1", ":1"},
+		{"ratio:a", "ratio:a"},
+		{"a:b:3", "a:b"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			if got := mWLinkDisplayText(test.target); got != test.want {
+				t.Errorf("mWLinkDisplayText(%q) = %q, want %q", test.target, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Words shorter than that are too common to tell senses apart
+const minSenseMatchWordLength = 4
+
+// mWThesaurusProvider never answers lookups itself, it only adds synonyms
+// and antonyms of the thesaurus to the senses of collegiate and learner's
+// dictionaries
+type mWThesaurusProvider struct {
+	*mWDictionaryProvider
+}
+
+func (provider *mWThesaurusProvider) enriches(providerName string) bool {
+	return providerName == mWProviderName || providerName == mWLearnersProviderName
+}
+
+// enrich matches lexemes by lemma and part of speech, then gives the words
+// of every thesaurus sense to the sense with the most similar definition
+func (provider *mWThesaurusProvider) enrich(response *dictionaryResponse, enrichment *dictionaryResponse) {
+	for entryIdx := range response.entries {
+		entry := &response.entries[entryIdx]
+		for lexemeIdx := range entry.lexemes {
+			lexeme := &entry.lexemes[lexemeIdx]
+			if lexeme.runOn {
+				continue
+			}
+
+			for _, thesaurusLexeme := range findMatchingLexemes(enrichment, lexeme) {
+				enrichLexemeSenses(lexeme, thesaurusLexeme)
+			}
+		}
+	}
+}
+
+// normalizedLemma drops syllable dots of MW headwords and the case
+func normalizedLemma(lemma string) string {
+	return strings.ToLower(strings.ReplaceAll(lemma, "·", ""))
+}
+
+// findMatchingLexemes returns lexemes of the enrichment with the same lemma
+// and part of speech as the lexeme
+func findMatchingLexemes(enrichment *dictionaryResponse, lexeme *entryLexeme) []*entryLexeme {
+	var lexemes []*entryLexeme
+	for entryIdx := range enrichment.entries {
+		entry := &enrichment.entries[entryIdx]
+		for idx := range entry.lexemes {
+			candidate := &entry.lexemes[idx]
+			if !candidate.runOn &&
+				normalizedLemma(candidate.lemma) == normalizedLemma(lexeme.lemma) &&
+				strings.EqualFold(candidate.partOfSpeech, lexeme.partOfSpeech) {
+				lexemes = append(lexemes, candidate)
+			}
+		}
+	}
+
+	return lexemes
+}
+
+func enrichLexemeSenses(lexeme *entryLexeme, thesaurusLexeme *entryLexeme) {
+	for _, thesaurusSense := range thesaurusLexeme.senses {
+		if len(thesaurusSense.data.Synonyms) == 0 && len(thesaurusSense.data.Antonyms) == 0 {
+			continue
+		}
+
+		idx := matchSenseByDefinition(lexeme, thesaurusSense)
+		if idx < 0 && len(thesaurusLexeme.senses) == 1 {
+			idx = firstDefinedSense(lexeme)
+		}
+
+		if idx < 0 {
+			continue
+		}
+
+		data := &lexeme.senses[idx].data
+		data.Synonyms = appendUniqueWords(data.Synonyms, thesaurusSense.data.Synonyms)
+		data.Antonyms = appendUniqueWords(data.Antonyms, thesaurusSense.data.Antonyms)
+	}
+}
+
+// matchSenseByDefinition finds the sense sharing the most content words with
+// the definition of a sense of another dictionary
+func matchSenseByDefinition(lexeme *entryLexeme, otherSense lexemeSense) int {
+	otherWords := definitionWords(otherSense.data.Definition)
+
+	bestIdx, bestOverlap := -1, 0
+	for idx, sense := range lexeme.senses {
+		overlap := 0
+		for word := range definitionWords(sense.data.Definition) {
+			if otherWords[word] {
+				overlap++
+			}
+		}
+
+		if overlap > bestOverlap {
+			bestIdx, bestOverlap = idx, overlap
+		}
+	}
+
+	return bestIdx
+}
+
+func definitionWords(definition string) map[string]bool {
+	words := map[string]bool{}
+	for _, word := range strings.FieldsFunc(strings.ToLower(stripHTML(definition)), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		if len([]rune(word)) >= minSenseMatchWordLength {
+			words[word] = true
+		}
+	}
+
+	return words
+}
+
+func firstDefinedSense(lexeme *entryLexeme) int {
+	for idx, sense := range lexeme.senses {
+		if sense.data.Definition != "" {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+func appendUniqueWords(words []string, added []string) []string {
+	for _, word := range added {
+		found := false
+		for _, existing := range words {
+			if strings.EqualFold(existing, word) {
+				found = true
+				break
+			}
+		}
+
+		if !found && len(words) < maxMWThesaurusWords {
+			words = append(words, word)
+		}
+	}
+
+	return words
+}
@@ -2,25 +2,39 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	_ "github.com/lib/pq"
 )
 
 var (
-	bot *tgbotapi.BotAPI
-	db  *sql.DB
+	bot       *tgbotapi.BotAPI
+	db        *sql.DB
+	providers *dictionaryProviderRegistry
+	// Saves done recently by user ID and save token
+	recentSaves = newCache("recent_saves", maxRecentSaves, recentSaveLifeSpan)
 )
 
 const (
-	queryCacheHoursLifeSpan = 1
-	appURL                  = "https://ezvocabulator.herokuapp.com/"
+	appURL                = "https://ezvocabulator.herokuapp.com/"
+	lookupCallbackPrefix  = "lookup:"
+	maxCallbackDataLength = 64
+	lookupsPerRow         = 3
+	maxKeyboardButtons    = 100
+	maxRecentSaves        = 10000
+	recentSaveLifeSpan    = 10 * time.Minute
+	maxConcurrentUpdates  = 16
+	cachePurgeInterval    = 10 * time.Minute
 )
 
 func initTelegram(botToken string) (*tgbotapi.BotAPI, error) {
@@ -74,53 +88,119 @@ func main() {
 		log.Fatalf("Error opening database: %q", err)
 	}
 
-	err = ensureTrainingTableExists(db)
+	err = runMigrations(db)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	providers, err = newDictionaryProviderRegistry(dictionaryProvidersOrder(), dictionaryEnrichersOrder(), db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	initSaveTokenSecret(botToken)
+	initAdmins()
+	initScheduler()
+
 	bot, err := initTelegram(botToken)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	updates := bot.ListenForWebhook("/" + bot.Token)
+	// Only the webhook is served. Default mux would expose /debug/vars of expvar
+	mux := http.NewServeMux()
+	updates := listenForWebhook(mux, "/"+bot.Token, bot.Buffer)
 
 	addr := fmt.Sprintf("0.0.0.0:%s", port)
-	go http.ListenAndServe(addr, nil)
+	go http.ListenAndServe(addr, mux)
+
+	go purgeCachesPeriodically(cachePurgeInterval, providers.lookups, recentSaves, fsrsUserWeights)
 
+	// Updates are handled in parallel, so a slow dictionary won't hold up others
+	concurrentUpdates := make(chan struct{}, maxConcurrentUpdates)
 	for update := range updates {
-		if update.Message == nil {
-			continue
-		}
+		concurrentUpdates <- struct{}{}
+		go func(update tgbotapi.Update) {
+			defer func() { <-concurrentUpdates }()
+			handleUpdate(update)
+		}(update)
+	}
+}
 
-		if strings.HasPrefix(update.Message.Text, StoreTrainingDataPrefix) {
-			handleStoreTrainingDataQuery(update.Message)
-			continue
+// listenForWebhook is ListenForWebhook of the bot on the given mux instead of
+// the default one
+func listenForWebhook(mux *http.ServeMux, pattern string, buffer int) tgbotapi.UpdatesChannel {
+	updates := make(chan tgbotapi.Update, buffer)
+
+	mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
+		var update tgbotapi.Update
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			log.Printf("Failed decoding update. %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
 		}
 
-		switch update.Message.Text {
-		case "/history":
-			handleUserTrainingDataRequest(update.Message)
-		default:
-			handleDictionaryRequest(update.Message)
-		}
+		updates <- update
+	})
+
+	return updates
+}
+
+func handleUpdate(update tgbotapi.Update) {
+	if update.CallbackQuery != nil {
+		handleCallbackQuery(update.CallbackQuery)
+		return
+	}
+
+	if update.Message == nil {
+		return
+	}
+
+	if strings.HasPrefix(update.Message.Text, StoreTrainingDataPrefix) {
+		handleStoreTrainingDataQuery(update.Message)
+		return
+	}
+
+	if isAdminCommand(update.Message.Text) {
+		handleAdminCommand(update.Message)
+		return
+	}
+
+	switch update.Message.Text {
+	case "/history":
+		handleUserTrainingDataRequest(update.Message)
+	case trainCommand:
+		handleTrainCommand(update.Message)
+	case optimizeCommand:
+		handleOptimizeCommand(update.Message)
+	case undoCommand:
+		handleUndoCommand(update.Message)
+	default:
+		handleDictionaryRequest(update.Message)
 	}
 }
 
 func handleStoreTrainingDataQuery(inMessage *tgbotapi.Message) {
-	trainingData, err := getTrainingData(inMessage.Text)
-	if err != nil {
-		sendSimpleReply(inMessage, "Cannot find corresponding dictionary request ... 😞\nThe request cache may be outdated. Try requesting it again ... I'll definetely find the word and store definitions for later! 🥺")
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	trainingData, err := trainingDataFromSaveToken(ctx, inMessage.Text)
+	if errors.Is(err, errInvalidSaveToken) {
+		sendSimpleReply(inMessage, "This save command is invalid ... 🤔")
+		return
+	} else if err != nil {
+		log.Println(err)
+		sendSimpleReply(inMessage, "Cannot find the definition anymore ... 😞\nTry requesting it again ... I'll definetely find the word and store definitions for later! 🥺")
 		return
 	}
 
-	err = storeTrainingData(db, inMessage.From.ID, trainingData)
+	stored, err := storeTrainingData(db, inMessage.From.ID, trainingData)
 	if err != nil {
 		handleErrorWithReply(inMessage, err)
+	} else if !stored {
+		sendSimpleReply(inMessage, fmt.Sprintf("Already saved '%s' ✅", trainingData.Item))
 	} else {
 		sendSimpleReply(inMessage, fmt.Sprintf("Stored '%s' ✅", trainingData.Item))
-		delete(queryToTrainingData, inMessage.Text)
 	}
 }
 
@@ -131,7 +211,7 @@ func handleUserTrainingDataRequest(inMessage *tgbotapi.Message) {
 		return
 	}
 
-	userTrainingData, err := getUserDataToTrain(db, inMessage.From.ID, userDataCount)
+	userTrainingData, err := getUserTrainingData(db, inMessage.From.ID, userDataCount)
 	if err != nil {
 		handleErrorWithReply(inMessage, err)
 		return
@@ -142,7 +222,7 @@ func handleUserTrainingDataRequest(inMessage *tgbotapi.Message) {
 
 	var buf bytes.Buffer
 	for i, trainingItem := range userTrainingData {
-		buf.WriteString(fmt.Sprintf("[%d] %s: %s\n", i, trainingItem.Item, trainingItem.ItemData.Definition))
+		buf.WriteString(fmt.Sprintf("[%d] %s: %s\n", i, trainingItem.Item, stripHTML(trainingItem.ItemData.Definition)))
 	}
 
 	file := tgbotapi.FileBytes{
@@ -155,39 +235,212 @@ func handleUserTrainingDataRequest(inMessage *tgbotapi.Message) {
 
 	_, err = bot.Send(msg)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Failed sending training data to user with ID %d. %s", inMessage.From.ID, err)
 	}
 }
 
-func handleDictionaryRequest(inMessage *tgbotapi.Message) {
-	mWResponse, err := getDefinitionFromMWDictionary(inMessage.Text)
+func handleCallbackQuery(query *tgbotapi.CallbackQuery) {
+	if query.Message == nil {
+		answerCallbackQuery(query, "")
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(query.Data, lookupCallbackPrefix):
+		answerCallbackQuery(query, "")
+		lookupAndReply(query.Message, strings.TrimPrefix(query.Data, lookupCallbackPrefix))
+	case strings.HasPrefix(query.Data, StoreTrainingDataPrefix):
+		handleStoreTrainingDataCallback(query)
+	case strings.HasPrefix(query.Data, trainCallbackPrefix):
+		handleTrainCallback(query)
+	default:
+		answerCallbackQuery(query, "")
+		log.Printf("Unknown callback query data '%s'", query.Data)
+	}
+}
+
+func handleStoreTrainingDataCallback(query *tgbotapi.CallbackQuery) {
+	// Repeated taps on the same button must not store the definition twice
+	saveKey := fmt.Sprintf("%d|%s", query.From.ID, query.Data)
+	if !recentSaves.add(saveKey, true) {
+		answerCallbackQuery(query, "Already stored ✅")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	trainingData, err := trainingDataFromSaveToken(ctx, query.Data)
+	if errors.Is(err, errInvalidSaveToken) {
+		log.Printf("Rejected save token '%s' from user with ID %d", query.Data, query.From.ID)
+		answerCallbackQuery(query, "This save button is invalid ... 🤔")
+		return
+	} else if err != nil {
+		log.Println(err)
+		recentSaves.delete(saveKey)
+		answerCallbackQuery(query, "Cannot find the definition anymore ... 😞 Request the word again to save it")
+		return
+	}
+
+	stored, err := storeTrainingData(db, query.From.ID, trainingData)
 	if err != nil {
-		handleErrorWithReply(inMessage, err)
+		log.Println(err)
+		recentSaves.delete(saveKey)
+		answerCallbackQuery(query, "Failed storing definition ... 🤔")
+		return
+	} else if !stored {
+		answerCallbackQuery(query, fmt.Sprintf("Already saved '%s' ✅", trainingData.Item))
 		return
-	} else if len(mWResponse.Entries) == 0 {
+	}
+
+	answerCallbackQuery(query, fmt.Sprintf("Stored '%s' ✅", trainingData.Item))
+}
+
+func answerCallbackQuery(query *tgbotapi.CallbackQuery, text string) {
+	if _, err := bot.AnswerCallbackQuery(tgbotapi.NewCallback(query.ID, text)); err != nil {
+		log.Printf("Failed answering callback query. %s", err)
+	}
+}
+
+func handleDictionaryRequest(inMessage *tgbotapi.Message) {
+	lookupAndReply(inMessage, inMessage.Text)
+}
+
+func lookupAndReply(inMessage *tgbotapi.Message, item string) {
+	ctx, cancel := context.WithTimeout(context.Background(), lookupTimeout)
+	defer cancel()
+
+	response, err := providers.lookup(ctx, item)
+	if errors.Is(err, errNothingFound) {
 		sendSimpleReply(inMessage, "Nothing has been found ... 😞")
 		return
+	} else if err != nil {
+		handleErrorWithReply(inMessage, err)
+		return
+	}
+
+	if len(response.entries) == 0 {
+		sendSuggestionsReply(inMessage, item, response.suggestions)
+		return
 	}
 
-	responseContent := convertMWDictionaryResponse(mWResponse)
+	responseContent := renderDictionaryResponse(response)
 
 	messageIDToReply := inMessage.MessageID
-	for _, responseContentPart := range splitResponseContents(responseContent.content, maxContentLength, '\n') {
+	responseContentParts := splitResponseContents(responseContent.content, maxContentLength)
+	saves := responseContent.saves
+	for idx, responseContentPart := range responseContentParts {
 		msg := tgbotapi.NewMessage(inMessage.Chat.ID, "")
 		msg.ReplyToMessageID = messageIDToReply
-		msg.ParseMode = "HTML"
-		msg.Text = responseContentPart
+		msg.Text = responseContentPart.content
+
+		// Every part gets buttons for saving definitions it contains
+		var rows [][]tgbotapi.InlineKeyboardButton
+		for len(saves) > 0 && saves[0].offset < responseContentPart.end {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(saves[0].label, saves[0].query)))
+			saves = saves[1:]
+		}
+
+		if idx == len(responseContentParts)-1 {
+			rows = append(rows, newLookupKeyboardRows(responseContent.lookups)...)
+		}
 
-		sentMsg, err := bot.Send(msg)
+		// Buttons which do not fit are sent in follow-up messages
+		keyboards := splitKeyboardRows(rows, maxKeyboardButtons)
+		if len(keyboards) > 0 {
+			msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboards[0]...)
+		}
+
+		sentMsg, err := sendHTMLMessage(msg)
 		if err != nil {
-			log.Fatal(err)
+			log.Printf("Failed sending definition of '%s'. %s", item, err)
+			return
 		}
 
-		cacheTrainingDataSet(responseContent.storeQueries)
 		messageIDToReply = sentMsg.MessageID
+
+		for keyboardIdx := 1; keyboardIdx < len(keyboards); keyboardIdx++ {
+			moreMsg := tgbotapi.NewMessage(inMessage.Chat.ID, "More definitions to save 👇")
+			moreMsg.ReplyToMessageID = messageIDToReply
+			moreMsg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(keyboards[keyboardIdx]...)
+
+			sentMsg, err = bot.Send(moreMsg)
+			if err != nil {
+				log.Printf("Failed sending save buttons of '%s'. %s", item, err)
+				return
+			}
+
+			messageIDToReply = sentMsg.MessageID
+		}
+	}
+}
+
+// splitKeyboardRows groups rows into keyboards of at most maxButtons buttons each
+func splitKeyboardRows(rows [][]tgbotapi.InlineKeyboardButton, maxButtons int) [][][]tgbotapi.InlineKeyboardButton {
+	var keyboards [][][]tgbotapi.InlineKeyboardButton
+	var keyboard [][]tgbotapi.InlineKeyboardButton
+	buttons := 0
+
+	for _, row := range rows {
+		if len(keyboard) > 0 && buttons+len(row) > maxButtons {
+			keyboards = append(keyboards, keyboard)
+			keyboard = nil
+			buttons = 0
+		}
+
+		keyboard = append(keyboard, row)
+		buttons += len(row)
+	}
+
+	if len(keyboard) > 0 {
+		keyboards = append(keyboards, keyboard)
+	}
+
+	return keyboards
+}
+
+func sendSuggestionsReply(inMessage *tgbotapi.Message, item string, suggestions []string) {
+	rows := newLookupKeyboardRows(suggestions)
+	if len(rows) == 0 {
+		sendSimpleReply(inMessage, "Nothing has been found ... 😞")
+		return
+	}
+
+	msg := tgbotapi.NewMessage(inMessage.Chat.ID, fmt.Sprintf("Cannot find '%s' ... 🤔 Did you mean one of these?", item))
+	msg.ReplyToMessageID = inMessage.MessageID
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("Failed sending reply. %s", err)
 	}
 }
 
+func newLookupKeyboardRows(words []string) [][]tgbotapi.InlineKeyboardButton {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+
+	added := map[string]bool{}
+	for _, word := range words {
+		data := lookupCallbackPrefix + word
+		if len(data) > maxCallbackDataLength || added[word] {
+			continue
+		}
+
+		added[word] = true
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(word, data))
+		if len(row) == lookupsPerRow {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
 func handleErrorWithReply(inMessage *tgbotapi.Message, err error) {
 	log.Println(err)
 	sendSimpleReply(inMessage, "Failed processing request ... 🤔")
@@ -198,6 +451,6 @@ func sendSimpleReply(inMessage *tgbotapi.Message, message string) {
 	msg.ReplyToMessageID = inMessage.MessageID
 
 	if _, err := bot.Send(msg); err != nil {
-		log.Fatal(err)
+		log.Printf("Failed sending reply. %s", err)
 	}
 }
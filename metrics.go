@@ -0,0 +1,14 @@
+package main
+
+import (
+	"expvar"
+)
+
+// Counters are published by expvar. Its /debug/vars is on the default mux,
+// which is not served, so admins read them with /metrics
+var (
+	mWDecodingIssues = expvar.NewMap("mw_decoding_issues")
+	cacheStatistics  = expvar.NewMap("caches")
+
+	botMetrics = []string{"mw_decoding_issues", "caches"}
+)
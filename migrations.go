@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Arbitrary key of the lock held while migrating, so replicas starting at the
+// same time do not migrate the same database at once
+const migrationsLockKey = 7171021
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is either a SQL file named "<version>_<name>.sql" or a data
+// migration done in Go. Every migration is applied in its own transaction
+type migration struct {
+	version int
+	name    string
+	sql     string
+	apply   func(tx *sql.Tx) error
+}
+
+// Data migrations which are easier to do in Go than in SQL
+var dataMigrations = []migration{
+	{version: 3, name: "backfill_normalized_tables", apply: backfillNormalizedTables},
+}
+
+func loadMigrations() ([]migration, error) {
+	fileNames, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := append([]migration(nil), dataMigrations...)
+	for _, file := range fileNames {
+		parts := strings.SplitN(strings.TrimSuffix(file.Name(), ".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("migration file '%s' is not named as '<version>_<name>.sql'", file.Name())
+		}
+
+		contents, err := migrationFiles.ReadFile(path.Join("migrations", file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{version: version, name: parts[1], sql: string(contents)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+
+	for idx := 1; idx < len(migrations); idx++ {
+		if migrations[idx].version == migrations[idx-1].version {
+			return nil, fmt.Errorf("migrations '%s' and '%s' have the same version %d", migrations[idx-1].name, migrations[idx].name, migrations[idx].version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// runMigrations applies migrations which are not recorded in schema_migrations yet
+func runMigrations(db *sql.DB) error {
+	log.Print("Checking database migrations")
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	// Session lock is released when the connection is closed
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationsLockKey)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, migrationsLockKey); err != nil {
+			log.Printf("Failed releasing migrations lock. %s", err)
+		}
+	}()
+
+	createTableStatement := `
+		CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version int PRIMARY KEY,
+			name text NOT NULL,
+			applied_at timestamptz NOT NULL DEFAULT now()
+		)`
+
+	_, err = conn.ExecContext(ctx, createTableStatement)
+	if err != nil {
+		return err
+	}
+
+	applied := map[int]bool{}
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return err
+	}
+
+	for rows.Next() {
+		var version int
+		if err = rows.Scan(&version); err != nil {
+			rows.Close()
+			return err
+		}
+
+		applied[version] = true
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, migration := range migrations {
+		if applied[migration.version] {
+			continue
+		}
+
+		log.Printf("Applying migration %04d %s", migration.version, migration.name)
+		err = applyMigration(ctx, conn, migration)
+		if err != nil {
+			return fmt.Errorf("failed applying migration %04d %s. %s", migration.version, migration.name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, migration migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if migration.apply != nil {
+		err = migration.apply(tx)
+	} else {
+		_, err = tx.Exec(migration.sql)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.version, migration.name)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	return err
+}
+
+// legacyTime reads time stored without time zone, which was written in the
+// local time of the bot
+func legacyTime(stored time.Time) time.Time {
+	return time.Date(stored.Year(), stored.Month(), stored.Day(),
+		stored.Hour(), stored.Minute(), stored.Second(), stored.Nanosecond(), time.Local)
+}
+
+// legacyScheduleState converts JSON of the whole training data logged with
+// reviews into the schedule state kept with cards
+func legacyScheduleState(state sql.NullString) (interface{}, error) {
+	if !state.Valid {
+		return nil, nil
+	}
+
+	var data trainingData
+	if err := json.Unmarshal([]byte(state.String), &data); err != nil {
+		return nil, err
+	}
+
+	schedule, err := json.Marshal(data.trainingSchedule)
+	if err != nil {
+		return nil, err
+	}
+
+	return string(schedule), nil
+}
+
+// legacyCard is a training row stored before cards were normalized
+type legacyCard struct {
+	id      int64
+	userID  int64
+	due     time.Time
+	data    trainingData
+	retired bool
+}
+
+// dedupeLegacyCards keeps the first card of every definition saved by a user
+// more than once and maps IDs of all cards to the IDs of the kept ones
+func dedupeLegacyCards(cards []legacyCard) ([]legacyCard, map[int64]int64) {
+	var kept []legacyCard
+	keptIDs := map[int64]int64{}
+	keptByDefinition := map[string]int64{}
+
+	for _, card := range cards {
+		// Entries are unique by item and data just as the definition here
+		itemData, _ := json.Marshal(card.data.ItemData)
+		definition := fmt.Sprintf("%d\n%s\n%s", card.userID, card.data.Item, itemData)
+
+		if keptID, ok := keptByDefinition[definition]; ok {
+			keptIDs[card.id] = keptID
+			continue
+		}
+
+		keptByDefinition[definition] = card.id
+		keptIDs[card.id] = card.id
+		kept = append(kept, card)
+	}
+
+	return kept, keptIDs
+}
+
+// backfillCard copies the legacy card along with its user and entry. The
+// statements are frozen here, the migration must not follow later changes of
+// the runtime queries
+func backfillCard(tx *sql.Tx, card legacyCard) error {
+	_, err := tx.Exec(`INSERT INTO users (id) VALUES ($1) ON CONFLICT DO NOTHING`, card.userID)
+	if err != nil {
+		return err
+	}
+
+	itemData, err := json.Marshal(card.data.ItemData)
+	if err != nil {
+		return err
+	}
+
+	// Updating the conflicting row makes it returned
+	var entryID int64
+	err = tx.QueryRow(`
+		INSERT INTO entries (item, data)
+		VALUES ($1, $2::jsonb)
+		ON CONFLICT (item, md5(data::text)) DO UPDATE
+		SET item = EXCLUDED.item
+		RETURNING id`, card.data.Item, string(itemData)).Scan(&entryID)
+	if err != nil {
+		return err
+	}
+
+	state, err := json.Marshal(card.data.trainingSchedule)
+	if err != nil {
+		return err
+	}
+
+	var cardID int64
+	err = tx.QueryRow(`
+		INSERT INTO cards (id, user_id, entry_id, due, scheduler, state, retired)
+		VALUES ($1, $2, $3, $4, $5, $6::jsonb, $7)
+		ON CONFLICT (user_id, entry_id) DO NOTHING
+		RETURNING id`,
+		card.id, card.userID, entryID, card.due, card.data.Scheduler, string(state), card.retired).Scan(&cardID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("training data %d of user with ID %d is stored already", card.id, card.userID)
+	}
+
+	return err
+}
+
+// backfillNormalizedTables moves training rows to users, entries and cards and
+// the review log to reviews. Cards keep IDs of training rows, so buttons of
+// already sent training messages keep working. Copies of the same definition
+// saved by a user become a single card with reviews of every copy. Legacy
+// tables are dropped by the next migration
+func backfillNormalizedTables(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT id, user_id, date, data, retired FROM training ORDER BY id`)
+	if err != nil {
+		return err
+	}
+
+	var legacyCards []legacyCard
+	for rows.Next() {
+		var card legacyCard
+		var data sql.NullString
+		if err = rows.Scan(&card.id, &card.userID, &card.due, &data, &card.retired); err != nil {
+			rows.Close()
+			return err
+		}
+
+		if !data.Valid || json.Unmarshal([]byte(data.String), &card.data) != nil {
+			log.Printf("Skipping invalid training data %d of user with ID %d", card.id, card.userID)
+			continue
+		}
+
+		card.due = legacyTime(card.due)
+		legacyCards = append(legacyCards, card)
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	cards, cardIDs := dedupeLegacyCards(legacyCards)
+	for _, card := range cards {
+		if err = backfillCard(tx, card); err != nil {
+			return err
+		}
+	}
+
+	// Next cards get IDs after the copied ones
+	_, err = tx.Exec(`SELECT setval(pg_get_serial_sequence('cards', 'id'), COALESCE(MAX(id), 0) + 1, false) FROM cards`)
+	if err != nil {
+		return err
+	}
+
+	reviewRows, err := tx.Query(`
+		SELECT user_id, card_id, reviewed_at, grade, time_taken_seconds, due_before, due_after,
+			interval_before, interval_after, state_before, state_after
+		FROM review_log
+		ORDER BY id`)
+	if err != nil {
+		return err
+	}
+
+	type legacyReview struct {
+		userID         int64
+		cardID         int64
+		reviewedAt     time.Time
+		grade          int
+		timeTaken      sql.NullInt64
+		dueBefore      sql.NullTime
+		dueAfter       sql.NullTime
+		intervalBefore sql.NullInt64
+		intervalAfter  sql.NullInt64
+		stateBefore    sql.NullString
+		stateAfter     sql.NullString
+	}
+
+	var legacyReviews []legacyReview
+	for reviewRows.Next() {
+		var review legacyReview
+		err = reviewRows.Scan(&review.userID, &review.cardID, &review.reviewedAt, &review.grade, &review.timeTaken,
+			&review.dueBefore, &review.dueAfter, &review.intervalBefore, &review.intervalAfter,
+			&review.stateBefore, &review.stateAfter)
+		if err != nil {
+			reviewRows.Close()
+			return err
+		}
+
+		legacyReviews = append(legacyReviews, review)
+	}
+
+	reviewRows.Close()
+	if err = reviewRows.Err(); err != nil {
+		return err
+	}
+
+	insertReviewStatement := `
+		INSERT INTO reviews (card_id, user_id, reviewed_at, grade, time_taken_seconds, due_before, due_after,
+			interval_before, interval_after, state_before, state_after)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10::jsonb, $11::jsonb)`
+
+	reviews := 0
+	for _, review := range legacyReviews {
+		cardID, ok := cardIDs[review.cardID]
+		if !ok {
+			continue
+		}
+
+		var dueBefore, dueAfter interface{}
+		if review.dueBefore.Valid {
+			dueBefore = legacyTime(review.dueBefore.Time)
+		}
+
+		if review.dueAfter.Valid {
+			dueAfter = legacyTime(review.dueAfter.Time)
+		}
+
+		var stateBefore, stateAfter interface{}
+		stateBefore, err = legacyScheduleState(review.stateBefore)
+		if err != nil {
+			return err
+		}
+
+		stateAfter, err = legacyScheduleState(review.stateAfter)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(insertReviewStatement, cardID, review.userID, legacyTime(review.reviewedAt), review.grade,
+			review.timeTaken, dueBefore, dueAfter, review.intervalBefore, review.intervalAfter, stateBefore, stateAfter)
+		if err != nil {
+			return err
+		}
+
+		reviews++
+	}
+
+	log.Printf("Backfilled %d cards of %d training rows and %d reviews", len(cards), len(legacyCards), reviews)
+	return nil
+}
@@ -0,0 +1,56 @@
+-- Schema the bot had before migrations, existing databases already have it
+CREATE TABLE IF NOT EXISTS training
+(
+	user_id int NOT NULL,
+	date timestamp NOT NULL,
+	data text
+);
+
+ALTER TABLE training
+ADD COLUMN IF NOT EXISTS id bigserial,
+ADD COLUMN IF NOT EXISTS retired boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS review_log
+(
+	id bigserial PRIMARY KEY,
+	user_id int NOT NULL,
+	card_id bigint NOT NULL,
+	reviewed_at timestamp NOT NULL,
+	grade int NOT NULL
+);
+
+ALTER TABLE review_log
+ADD COLUMN IF NOT EXISTS time_taken_seconds int,
+ADD COLUMN IF NOT EXISTS due_before timestamp,
+ADD COLUMN IF NOT EXISTS due_after timestamp,
+ADD COLUMN IF NOT EXISTS interval_before int,
+ADD COLUMN IF NOT EXISTS interval_after int,
+ADD COLUMN IF NOT EXISTS state_before text,
+ADD COLUMN IF NOT EXISTS state_after text;
+
+CREATE INDEX IF NOT EXISTS review_log_user_card ON review_log (user_id, card_id, reviewed_at);
+
+CREATE TABLE IF NOT EXISTS fsrs_parameters
+(
+	user_id int PRIMARY KEY,
+	weights text NOT NULL,
+	reviews int NOT NULL,
+	optimized_at timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS lookups
+(
+	provider text NOT NULL,
+	query text NOT NULL,
+	fetched_at timestamp NOT NULL,
+	response text NOT NULL,
+	PRIMARY KEY (provider, query)
+);
+
+CREATE TABLE IF NOT EXISTS provider_usage
+(
+	provider text NOT NULL,
+	day date NOT NULL,
+	calls int NOT NULL,
+	PRIMARY KEY (provider, day)
+);
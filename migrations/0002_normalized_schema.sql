@@ -0,0 +1,60 @@
+-- Telegram user IDs do not fit into 32 bits anymore
+CREATE TABLE users
+(
+	id bigint PRIMARY KEY,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+-- Stored dictionary items shared by users who saved the same definition
+CREATE TABLE entries
+(
+	id bigserial PRIMARY KEY,
+	item text NOT NULL,
+	data jsonb NOT NULL,
+	created_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX entries_item_data ON entries (item, md5(data::text));
+
+CREATE TABLE cards
+(
+	id bigserial PRIMARY KEY,
+	user_id bigint NOT NULL REFERENCES users (id),
+	entry_id bigint NOT NULL REFERENCES entries (id),
+	due timestamptz NOT NULL,
+	scheduler text NOT NULL DEFAULT '',
+	state jsonb NOT NULL,
+	retired boolean NOT NULL DEFAULT false,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	UNIQUE (user_id, entry_id)
+);
+
+CREATE INDEX cards_user_due ON cards (user_id, due);
+
+CREATE TABLE reviews
+(
+	id bigserial PRIMARY KEY,
+	card_id bigint NOT NULL REFERENCES cards (id) ON DELETE CASCADE,
+	user_id bigint NOT NULL REFERENCES users (id),
+	reviewed_at timestamptz NOT NULL,
+	grade smallint NOT NULL,
+	time_taken_seconds int,
+	due_before timestamptz,
+	due_after timestamptz,
+	interval_before int,
+	interval_after int,
+	-- Scheduler state of the card, reviews logged without it cannot be undone
+	state_before jsonb,
+	state_after jsonb
+);
+
+CREATE INDEX reviews_user_reviewed_at ON reviews (user_id, reviewed_at);
+CREATE INDEX reviews_card_reviewed_at ON reviews (card_id, reviewed_at);
+
+ALTER TABLE fsrs_parameters
+ALTER COLUMN user_id TYPE bigint,
+ALTER COLUMN optimized_at TYPE timestamptz;
+
+-- Fetch time used to be stored in the local time of the bot and read as UTC
+ALTER TABLE lookups
+ALTER COLUMN fetched_at TYPE timestamptz;
@@ -0,0 +1,3 @@
+-- Everything is backfilled into cards and reviews by the previous migration
+DROP TABLE training;
+DROP TABLE review_log;
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func testLegacyCard(id int64, userID int64, item string, definition string) legacyCard {
+	return legacyCard{
+		id:     id,
+		userID: userID,
+		data: trainingData{
+			Item:     item,
+			ItemData: dictionaryItemData{Definition: definition},
+		},
+	}
+}
+
+func TestDedupeLegacyCards(t *testing.T) {
+	tests := []struct {
+		name        string
+		cards       []legacyCard
+		wantKept    []int64
+		wantKeptIDs map[int64]int64
+	}{
+		{"none", nil, nil, map[int64]int64{}},
+		{"unique", []legacyCard{
+			testLegacyCard(1, 10, "test", "a trial"),
+			testLegacyCard(2, 10, "test", "an exam"),
+			testLegacyCard(3, 10, "trial", "a trial"),
+		}, []int64{1, 2, 3}, map[int64]int64{1: 1, 2: 2, 3: 3}},
+		{"duplicates kept once", []legacyCard{
+			testLegacyCard(1, 10, "test", "a trial"),
+			testLegacyCard(2, 10, "test", "an exam"),
+			testLegacyCard(3, 10, "test", "a trial"),
+			testLegacyCard(4, 10, "test", "a trial"),
+		}, []int64{1, 2}, map[int64]int64{1: 1, 2: 2, 3: 1, 4: 1}},
+		{"same definition of other users", []legacyCard{
+			testLegacyCard(1, 10, "test", "a trial"),
+			testLegacyCard(2, 20, "test", "a trial"),
+			testLegacyCard(3, 20, "test", "a trial"),
+		}, []int64{1, 2}, map[int64]int64{1: 1, 2: 2, 3: 2}},
+		{"schedule does not matter", []legacyCard{
+			testLegacyCard(1, 10, "test", "a trial"),
+			{id: 2, userID: 10, data: trainingData{
+				Item:             "test",
+				ItemData:         dictionaryItemData{Definition: "a trial"},
+				trainingSchedule: trainingSchedule{Iteration: 5},
+			}},
+		}, []int64{1}, map[int64]int64{1: 1, 2: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kept, keptIDs := dedupeLegacyCards(test.cards)
+
+			var keptCards []int64
+			for _, card := range kept {
+				keptCards = append(keptCards, card.id)
+			}
+
+			if !reflect.DeepEqual(keptCards, test.wantKept) {
+				t.Errorf("kept cards = %v, want %v", keptCards, test.wantKept)
+			}
+
+			if !reflect.DeepEqual(keptIDs, test.wantKeptIDs) {
+				t.Errorf("kept IDs = %v, want %v", keptIDs, test.wantKeptIDs)
+			}
+		})
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("loadMigrations() failed. %s", err)
+	}
+
+	want := []struct {
+		version int
+		name    string
+		isData  bool
+	}{
+		{1, "baseline", false},
+		{2, "normalized_schema", false},
+		{3, "backfill_normalized_tables", true},
+		{4, "drop_legacy_tables", false},
+	}
+
+	if len(migrations) != len(want) {
+		t.Fatalf("loadMigrations() returned %d migrations, want %d", len(migrations), len(want))
+	}
+
+	for idx, migration := range migrations {
+		if migration.version != want[idx].version || migration.name != want[idx].name ||
+			(migration.apply != nil) != want[idx].isData || (migration.sql == "") != want[idx].isData {
+			t.Errorf("migration %d is %04d %s, want %04d %s", idx, migration.version, migration.name, want[idx].version, want[idx].name)
+		}
+	}
+}
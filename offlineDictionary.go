@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	offlineProviderName = "offline"
+	starDictIfoMagic    = "StarDict's dict ifo file"
+	dictdBase64Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+	// dictd keeps database information in articles with this prefix
+	dictdInfoPrefix = "00-database-"
+)
+
+// offlineDictionary is a StarDict or dictd dictionary loaded from disk. Only
+// the index is kept in memory, articles are read on request
+type offlineDictionary struct {
+	name string
+	// Article locations by lowercase headword
+	index  map[string][]offlineArticleLocation
+	reader articleReader
+	// StarDict field types of every article, empty if each field has its type
+	sameTypeSequence string
+	isStarDict       bool
+}
+
+type offlineArticleLocation struct {
+	headword string
+	offset   int64
+	size     int
+}
+
+// offlineResponse is what the offline provider fetches, articles are decoded
+// into the common model separately like the responses of online providers
+type offlineResponse struct {
+	Articles []offlineArticle `json:"articles"`
+}
+
+type offlineArticle struct {
+	Dictionary string         `json:"dictionary"`
+	Headword   string         `json:"headword"`
+	Fields     []offlineField `json:"fields"`
+}
+
+type offlineField struct {
+	// StarDict field type, dictd articles are plain text ("m")
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type offlineDictionaryProvider struct {
+	dictionaries []*offlineDictionary
+}
+
+// newOfflineDictionaryProvider loads every StarDict and dictd dictionary found
+// in OFFLINE_DICTIONARIES_DIR directory and its subdirectories
+func newOfflineDictionaryProvider() (dictionaryProvider, error) {
+	dir := os.Getenv("OFFLINE_DICTIONARIES_DIR")
+	if dir == "" {
+		return nil, errProviderNotConfigured
+	}
+
+	var provider offlineDictionaryProvider
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		var dictionary *offlineDictionary
+		switch {
+		case strings.HasSuffix(path, ".ifo"):
+			dictionary, err = loadStarDictDictionary(strings.TrimSuffix(path, ".ifo"))
+		case strings.HasSuffix(path, ".index"):
+			dictionary, err = loadDictdDictionary(strings.TrimSuffix(path, ".index"))
+		default:
+			return nil
+		}
+
+		if err != nil {
+			log.Printf("Skipping offline dictionary '%s'. %s", path, err)
+			return nil
+		}
+
+		log.Printf("Loaded offline dictionary '%s' with %d headwords", dictionary.name, len(dictionary.index))
+		provider.dictionaries = append(provider.dictionaries, dictionary)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(provider.dictionaries) == 0 {
+		return nil, fmt.Errorf("no dictionaries found in '%s'", dir)
+	}
+
+	return &provider, nil
+}
+
+func openDictionaryArticles(basePath string) (articleReader, error) {
+	for _, path := range []string{basePath + ".dict.dz", basePath + ".dict"} {
+		if _, err := os.Stat(path); err == nil {
+			return openArticleReader(path)
+		}
+	}
+
+	return nil, fmt.Errorf("no articles file for '%s'", basePath)
+}
+
+func (dictionary *offlineDictionary) addArticle(location offlineArticleLocation) {
+	key := strings.ToLower(location.headword)
+	dictionary.index[key] = append(dictionary.index[key], location)
+}
+
+func loadStarDictDictionary(basePath string) (*offlineDictionary, error) {
+	ifo, err := ioutil.ReadFile(basePath + ".ifo")
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Split(strings.ReplaceAll(string(ifo), "\r\n", "\n"), "\n")
+	if strings.TrimSpace(lines[0]) != starDictIfoMagic {
+		return nil, errors.New("not a StarDict ifo file")
+	}
+
+	info := map[string]string{}
+	for _, line := range lines[1:] {
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			info[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	dictionary := &offlineDictionary{
+		name:             info["bookname"],
+		index:            map[string][]offlineArticleLocation{},
+		sameTypeSequence: info["sametypesequence"],
+		isStarDict:       true,
+	}
+
+	if dictionary.name == "" {
+		dictionary.name = filepath.Base(basePath)
+	}
+
+	offsetSize := 4
+	if info["idxoffsetbits"] == "64" {
+		offsetSize = 8
+	}
+
+	idx, err := readMaybeGzipFile(basePath + ".idx")
+	if err != nil {
+		return nil, err
+	}
+
+	for len(idx) > 0 {
+		end := bytes.IndexByte(idx, 0)
+		if end < 0 || len(idx) < end+1+offsetSize+4 {
+			return nil, errors.New("StarDict index is truncated")
+		}
+
+		location := offlineArticleLocation{headword: string(idx[:end])}
+		idx = idx[end+1:]
+
+		if offsetSize == 8 {
+			location.offset = int64(binary.BigEndian.Uint64(idx))
+		} else {
+			location.offset = int64(binary.BigEndian.Uint32(idx))
+		}
+
+		location.size = int(binary.BigEndian.Uint32(idx[offsetSize:]))
+		idx = idx[offsetSize+4:]
+
+		dictionary.addArticle(location)
+	}
+
+	dictionary.reader, err = openDictionaryArticles(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return dictionary, nil
+}
+
+func loadDictdDictionary(basePath string) (*offlineDictionary, error) {
+	file, err := os.Open(basePath + ".index")
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	dictionary := &offlineDictionary{
+		name:  filepath.Base(basePath),
+		index: map[string][]offlineArticleLocation{},
+	}
+
+	var shortName *offlineArticleLocation
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "\t")
+		if len(parts) < 3 {
+			continue
+		}
+
+		offset, offsetErr := decodeDictdNumber(parts[1])
+		size, sizeErr := decodeDictdNumber(parts[2])
+		if offsetErr != nil || sizeErr != nil {
+			return nil, fmt.Errorf("invalid dictd index line '%s'", scanner.Text())
+		}
+
+		location := offlineArticleLocation{headword: parts[0], offset: offset, size: int(size)}
+		if strings.HasPrefix(location.headword, dictdInfoPrefix) {
+			if location.headword == dictdInfoPrefix+"short" {
+				shortName = &location
+			}
+
+			continue
+		}
+
+		dictionary.addArticle(location)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	dictionary.reader, err = openDictionaryArticles(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Short name article is the headword line followed by the name
+	if shortName != nil {
+		if article, err := dictionary.reader.readArticle(shortName.offset, shortName.size); err == nil {
+			lines := strings.Split(strings.TrimSpace(string(article)), "\n")
+			if name := strings.TrimSpace(lines[len(lines)-1]); name != "" {
+				dictionary.name = name
+			}
+		}
+	}
+
+	return dictionary, nil
+}
+
+// decodeDictdNumber decodes numbers written with base64 digits
+func decodeDictdNumber(encoded string) (int64, error) {
+	var number int64
+	for _, digit := range encoded {
+		value := strings.IndexRune(dictdBase64Alphabet, digit)
+		if value < 0 {
+			return 0, fmt.Errorf("invalid dictd number '%s'", encoded)
+		}
+
+		number = number*64 + int64(value)
+	}
+
+	return number, nil
+}
+
+// parseStarDictFields splits article data into typed fields. Lower case types
+// are zero terminated, upper case ones are prefixed with their size. The last
+// field of a same type sequence takes the rest of the data
+func parseStarDictFields(data []byte, sameTypeSequence string) []offlineField {
+	var fields []offlineField
+
+	for idx := 0; len(data) > 0; idx++ {
+		var fieldType byte
+		if sameTypeSequence == "" {
+			fieldType = data[0]
+			data = data[1:]
+		} else if idx < len(sameTypeSequence) {
+			fieldType = sameTypeSequence[idx]
+		} else {
+			break
+		}
+
+		isLast := sameTypeSequence != "" && idx == len(sameTypeSequence)-1
+
+		var field []byte
+		switch {
+		case isLast:
+			field, data = data, nil
+		case fieldType >= 'a' && fieldType <= 'z':
+			end := bytes.IndexByte(data, 0)
+			if end < 0 {
+				end = len(data)
+			}
+
+			field = data[:end]
+			data = data[end:]
+			if len(data) > 0 {
+				data = data[1:]
+			}
+		default:
+			if len(data) < 4 {
+				return fields
+			}
+
+			size := int(binary.BigEndian.Uint32(data))
+			data = data[4:]
+			if size > len(data) {
+				return fields
+			}
+
+			field, data = data[:size], data[size:]
+		}
+
+		fields = append(fields, offlineField{Type: string(fieldType), Text: string(field)})
+	}
+
+	return fields
+}
+
+func (provider *offlineDictionaryProvider) name() string {
+	return offlineProviderName
+}
+
+func (provider *offlineDictionaryProvider) isLocal() bool {
+	return true
+}
+
+func (provider *offlineDictionaryProvider) fetch(ctx context.Context, item string) ([]byte, error) {
+	key := strings.ToLower(strings.TrimSpace(item))
+
+	var response offlineResponse
+	for _, dictionary := range provider.dictionaries {
+		for _, location := range dictionary.index[key] {
+			data, err := dictionary.reader.readArticle(location.offset, location.size)
+			if err != nil {
+				log.Printf("Failed reading '%s' article of '%s' offline dictionary. %s", location.headword, dictionary.name, err)
+				continue
+			}
+
+			article := offlineArticle{
+				Dictionary: dictionary.name,
+				Headword:   location.headword,
+			}
+
+			if dictionary.isStarDict {
+				article.Fields = parseStarDictFields(data, dictionary.sameTypeSequence)
+			} else {
+				article.Fields = []offlineField{{Type: "m", Text: string(data)}}
+			}
+
+			response.Articles = append(response.Articles, article)
+		}
+	}
+
+	if len(response.Articles) == 0 {
+		return nil, errNothingFound
+	}
+
+	return json.Marshal(response)
+}
+
+func (provider *offlineDictionaryProvider) decode(contents []byte) (*dictionaryResponse, error) {
+	var offlineResponse offlineResponse
+	err := json.Unmarshal(contents, &offlineResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	response := convertOfflineResponse(&offlineResponse)
+	if len(response.entries) == 0 {
+		return nil, errNothingFound
+	}
+
+	return response, nil
+}
+
+// convertOfflineResponse makes an entry of every article. Articles have no
+// structure to rely on, so every paragraph becomes a sense of its own
+func convertOfflineResponse(offlineResponse *offlineResponse) *dictionaryResponse {
+	var response dictionaryResponse
+
+	for idx, article := range offlineResponse.Articles {
+		entry := dictionaryEntry{
+			id:   fmt.Sprintf("%s:%d", article.Headword, idx+1),
+			item: article.Headword,
+		}
+
+		lexeme := entryLexeme{
+			lemma:  article.Headword,
+			labels: []string{article.Dictionary},
+		}
+
+		for _, field := range article.Fields {
+			text := field.Text
+			switch field.Type {
+			case "t":
+				entry.pronunciations = append(entry.pronunciations, entryPronunciation{transcriptions: []string{text}})
+				continue
+			case "h", "g", "x":
+				text = stripHTML(strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n").Replace(text))
+			case "m", "l", "y", "k":
+				// Plain text
+			default:
+				// Binary fields like pictures or sounds can't be shown
+				continue
+			}
+
+			for _, paragraph := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n\n") {
+				paragraph = strings.TrimSpace(paragraph)
+
+				// dictd articles start with the headword line
+				if lines := strings.SplitN(paragraph, "\n", 2); strings.EqualFold(strings.TrimSpace(lines[0]), article.Headword) {
+					paragraph = ""
+					if len(lines) > 1 {
+						paragraph = strings.TrimSpace(lines[1])
+					}
+				}
+
+				if paragraph == "" {
+					continue
+				}
+
+				lexeme.senses = append(lexeme.senses, lexemeSense{data: dictionaryItemData{Definition: escapeHTML(paragraph)}})
+			}
+		}
+
+		if len(lexeme.senses) == 0 {
+			continue
+		}
+
+		entry.lexemes = append(entry.lexemes, lexeme)
+		response.entries = append(response.entries, entry)
+	}
+
+	return &response
+}
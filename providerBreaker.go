@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Breaker opens after that many failures in a row
+	breakerFailureThreshold = 5
+	breakerCooldown         = time.Minute
+	breakerMaxCooldown      = 30 * time.Minute
+	// Share of the daily limit kept in reserve, so the limit itself is never hit
+	quotaReserveRatio = 0.05
+)
+
+var (
+	errProviderUnavailable = errors.New("provider is temporarily skipped")
+
+	// Default daily limits, zero means unlimited
+	defaultProviderDailyLimits = map[string]int{
+		mWProviderName:          1000,
+		mWLearnersProviderName:  1000,
+		mWThesaurusProviderName: 1000,
+	}
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	// Single trial call is let through to check whether the provider recovered
+	breakerHalfOpen
+)
+
+func (state breakerState) String() string {
+	switch state {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// providerBreaker keeps track of provider failures and daily calls and tells
+// whether the provider can be called at the moment
+type providerBreaker struct {
+	mu         sync.Mutex
+	provider   string
+	dailyLimit int
+	state      breakerState
+	reason     string
+	openUntil  time.Time
+	cooldown   time.Duration
+	failures   int
+	// Calls made today, kept in memory when there is no database
+	day   string
+	calls int
+}
+
+type providerBreakerStatus struct {
+	provider   string
+	state      breakerState
+	reason     string
+	openUntil  time.Time
+	failures   int
+	calls      int
+	dailyLimit int
+}
+
+func newProviderBreaker(provider string) *providerBreaker {
+	return &providerBreaker{
+		provider:   provider,
+		dailyLimit: providerDailyLimit(provider),
+		cooldown:   breakerCooldown,
+	}
+}
+
+// providerDailyLimit reads limits from "provider=limit,..." list of
+// PROVIDER_DAILY_LIMITS environment variable
+func providerDailyLimit(provider string) int {
+	for _, pair := range strings.Split(os.Getenv("PROVIDER_DAILY_LIMITS"), ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != provider {
+			continue
+		}
+
+		limit, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if err != nil || limit < 0 {
+			log.Printf("Ignoring invalid daily limit '%s' of '%s' provider", parts[1], provider)
+			break
+		}
+
+		return limit
+	}
+
+	return defaultProviderDailyLimits[provider]
+}
+
+// Provider quotas are reset at UTC midnight
+func usageDay(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+func nextUsageDay(now time.Time) time.Time {
+	year, month, day := now.UTC().Date()
+	return time.Date(year, month, day+1, 0, 0, 0, 0, time.UTC)
+}
+
+// allow tells whether the provider can be called. Open breaker lets a single
+// trial call through once it cools down
+func (breaker *providerBreaker) allow() bool {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch breaker.state {
+	case breakerOpen:
+		if time.Now().Before(breaker.openUntil) {
+			return false
+		}
+
+		breaker.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// Trial call is in progress
+		return false
+	default:
+		return true
+	}
+}
+
+// recordCall accounts the call and opens the breaker till the next day once
+// the provider gets close to its daily limit. Calls counted in database take
+// precedence over the ones counted in memory as they include other replicas
+func (breaker *providerBreaker) recordCall(storedCalls int) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	now := time.Now()
+	if day := usageDay(now); breaker.day != day {
+		breaker.day = day
+		breaker.calls = 0
+	}
+
+	breaker.calls++
+	if storedCalls > 0 {
+		breaker.calls = storedCalls
+	}
+
+	if breaker.dailyLimit == 0 {
+		return
+	}
+
+	reserve := int(float64(breaker.dailyLimit) * quotaReserveRatio)
+	if breaker.calls >= breaker.dailyLimit-reserve {
+		breaker.openLocked(nextUsageDay(now), fmt.Sprintf("%d of %d daily calls are made", breaker.calls, breaker.dailyLimit))
+	}
+}
+
+// recordResult closes the breaker on success and opens it on failures. Calls
+// cut off by the caller's context or made while the provider is not ready
+// tell nothing about the provider
+func (breaker *providerBreaker) recordResult(err error) {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errProviderUnavailable):
+		if breaker.state == breakerHalfOpen {
+			// Trial is inconclusive, so the next call is a trial again
+			breaker.state = breakerOpen
+		}
+	case err == nil || errors.Is(err, errNothingFound):
+		if breaker.state == breakerHalfOpen {
+			log.Printf("Closing circuit breaker of '%s' provider", breaker.provider)
+			breaker.state = breakerClosed
+			breaker.reason = ""
+		}
+
+		breaker.failures = 0
+		breaker.cooldown = breakerCooldown
+	case errors.Is(err, errQuotaExceeded):
+		breaker.openLocked(nextUsageDay(time.Now()), "provider reported exhausted quota")
+	default:
+		breaker.failures++
+		if breaker.state == breakerHalfOpen {
+			// Failing trial means the provider still has not recovered
+			breaker.cooldown *= 2
+			if breaker.cooldown > breakerMaxCooldown {
+				breaker.cooldown = breakerMaxCooldown
+			}
+
+			breaker.openLocked(time.Now().Add(breaker.cooldown), err.Error())
+		} else if breaker.state == breakerClosed && breaker.failures >= breakerFailureThreshold {
+			breaker.openLocked(time.Now().Add(breaker.cooldown), fmt.Sprintf("%d failures in a row, last: %s", breaker.failures, err))
+		}
+	}
+}
+
+func (breaker *providerBreaker) openLocked(until time.Time, reason string) {
+	if breaker.state != breakerOpen || until.After(breaker.openUntil) {
+		log.Printf("Opening circuit breaker of '%s' provider till %s. %s", breaker.provider, until.Format(time.RFC3339), reason)
+		breaker.openUntil = until
+	}
+
+	breaker.state = breakerOpen
+	breaker.reason = reason
+}
+
+func (breaker *providerBreaker) status() providerBreakerStatus {
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	status := providerBreakerStatus{
+		provider:   breaker.provider,
+		state:      breaker.state,
+		reason:     breaker.reason,
+		openUntil:  breaker.openUntil,
+		failures:   breaker.failures,
+		dailyLimit: breaker.dailyLimit,
+	}
+
+	if breaker.day == usageDay(time.Now()) {
+		status.calls = breaker.calls
+	}
+
+	return status
+}
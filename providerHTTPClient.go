@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	providerRequestTimeout   = 10 * time.Second
+	providerMaxAttempts      = 3
+	providerRetryBaseDelay   = 250 * time.Millisecond
+	providerRetryMaxDelay    = 5 * time.Second
+	providerMaxResponseBytes = 10 << 20
+)
+
+var (
+	errProviderAuth     = errors.New("provider rejected credentials")
+	errProviderUpstream = errors.New("provider failed")
+)
+
+// providerHTTPError tells which request failed and why. It matches
+// errProviderAuth, errQuotaExceeded, errNothingFound or errProviderUpstream
+// with errors.Is
+type providerHTTPError struct {
+	kind   error
+	host   string
+	status int
+	err    error
+}
+
+func (e *providerHTTPError) Error() string {
+	switch {
+	case e.err != nil:
+		return fmt.Sprintf("%s (%s): %s", e.kind, e.host, e.err)
+	case e.status != 0:
+		return fmt.Sprintf("%s (%s): response status %d", e.kind, e.host, e.status)
+	default:
+		return fmt.Sprintf("%s (%s)", e.kind, e.host)
+	}
+}
+
+func (e *providerHTTPError) Unwrap() error {
+	return e.kind
+}
+
+// Is matches the cause as well, so requests cut off by the context are told
+// from failures of the provider
+func (e *providerHTTPError) Is(target error) bool {
+	return e.err != nil && errors.Is(e.err, target)
+}
+
+type providerCallRecorderKey struct{}
+
+// withProviderCallRecorder makes the client call record for every request
+// which is actually sent, so attempts failed before sending are not accounted
+func withProviderCallRecorder(ctx context.Context, record func()) context.Context {
+	return context.WithValue(ctx, providerCallRecorderKey{}, record)
+}
+
+type providerHTTPClient struct {
+	client         *http.Client
+	requestTimeout time.Duration
+	maxAttempts    int
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+}
+
+var providerClient = &providerHTTPClient{
+	client:         &http.Client{},
+	requestTimeout: providerRequestTimeout,
+	maxAttempts:    providerMaxAttempts,
+	baseDelay:      providerRetryBaseDelay,
+	maxDelay:       providerRetryMaxDelay,
+}
+
+// do sends the request until it succeeds, fails permanently, runs out of
+// attempts or the context is done. Network failures, server errors and
+// throttling with Retry-After are considered transient. Every attempt is
+// limited by the request timeout and the attempt is retried only while the
+// context has time left for the delay and a whole attempt
+func (c *providerHTTPClient) do(ctx context.Context, request *http.Request) ([]byte, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < c.maxAttempts; attempt++ {
+		contents, retryAfter, err := c.send(ctx, request)
+		if err == nil {
+			return contents, nil
+		}
+
+		lastErr = err
+		if retryAfter < 0 || attempt == c.maxAttempts-1 {
+			break
+		}
+
+		delay := c.backoff(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+
+		// Retry which would be cut off by the deadline only delays the failure
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay+c.requestTimeout {
+			break
+		}
+
+		log.Printf("Retrying request to %s in %s. %s", request.URL.Host, delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, &providerHTTPError{kind: errProviderUpstream, host: request.URL.Host, err: ctx.Err()}
+		case <-timer.C:
+		}
+	}
+
+	return nil, lastErr
+}
+
+// send makes a single attempt. Negative retry delay means the failure is
+// permanent, zero means the default backoff applies
+func (c *providerHTTPClient) send(ctx context.Context, request *http.Request) ([]byte, time.Duration, error) {
+	host := request.URL.Host
+
+	attemptCtx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	defer cancel()
+
+	if record, ok := ctx.Value(providerCallRecorderKey{}).(func()); ok {
+		var once sync.Once
+		attemptCtx = httptrace.WithClientTrace(attemptCtx, &httptrace.ClientTrace{
+			WroteHeaders: func() { once.Do(record) },
+		})
+	}
+
+	response, err := c.client.Do(request.Clone(attemptCtx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, &providerHTTPError{kind: errProviderUpstream, host: host, err: ctx.Err()}
+		}
+
+		if attemptCtx.Err() != nil {
+			err = fmt.Errorf("no response in %s", c.requestTimeout)
+		}
+
+		return nil, 0, &providerHTTPError{kind: errProviderUpstream, host: host, err: err}
+	}
+
+	defer response.Body.Close()
+
+	switch status := response.StatusCode; {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return nil, -1, &providerHTTPError{kind: errProviderAuth, host: host, status: status}
+	case status == http.StatusNotFound:
+		return nil, -1, &providerHTTPError{kind: errNothingFound, host: host, status: status}
+	case status == http.StatusTooManyRequests:
+		// Exhausted daily quota is not worth retrying unless the provider says when
+		retryAfter := parseRetryAfter(response.Header.Get("Retry-After"))
+		if retryAfter <= 0 {
+			retryAfter = -1
+		}
+
+		return nil, retryAfter, &providerHTTPError{kind: errQuotaExceeded, host: host, status: status}
+	case status >= 500:
+		return nil, parseRetryAfter(response.Header.Get("Retry-After")), &providerHTTPError{kind: errProviderUpstream, host: host, status: status}
+	case status < 200 || status > 299:
+		return nil, -1, &providerHTTPError{kind: errProviderUpstream, host: host, status: status}
+	}
+
+	contents, err := ioutil.ReadAll(http.MaxBytesReader(nil, response.Body, providerMaxResponseBytes))
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, -1, &providerHTTPError{kind: errProviderUpstream, host: host, err: ctx.Err()}
+		}
+
+		return nil, 0, &providerHTTPError{kind: errProviderUpstream, host: host, err: err}
+	}
+
+	return contents, 0, nil
+}
+
+// backoff is exponential with full jitter, so retries of parallel requests spread out
+func (c *providerHTTPClient) backoff(attempt int) time.Duration {
+	delay := c.baseDelay << attempt
+	if delay <= 0 || delay > c.maxDelay {
+		delay = c.maxDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// parseRetryAfter supports both delay in seconds and HTTP date forms
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && time.Until(date) > 0 {
+		return time.Until(date)
+	}
+
+	return 0
+}
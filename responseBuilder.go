@@ -2,42 +2,59 @@ package main
 
 import (
 	"fmt"
-	"math/rand"
 	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
 )
 
 type responseBuilder struct {
-	sb           strings.Builder
-	storeQueries map[string]trainingData
+	sb      strings.Builder
+	saves   []responseSave
+	lookups []string
 }
 
 type responseContent struct {
-	content      string
-	storeQueries map[string]trainingData
+	content string
+	// Save actions in order of appearance in the content
+	saves []responseSave
+	// Words which can be looked up right from the response
+	lookups []string
 }
 
-func generateStoreLexemeDefinitionQuery() string {
-	return fmt.Sprintf("%s_%s", StoreTrainingDataPrefix, randStringBytes(6))
+type responseSave struct {
+	query string
+	label string
+	// Offset in the content where the saved part starts
+	offset int
 }
 
-const strContent = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-
-func randStringBytes(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = strContent[rand.Intn(len(strContent))]
-	}
+type responseContentPart struct {
+	content string
+	// Offset in the original content where the part ends
+	end int
+}
 
-	return string(b)
+func newResponseBuilder() *responseBuilder {
+	return &responseBuilder{}
 }
 
 func (builder *responseBuilder) append(contentPart string) {
 	builder.sb.WriteString(contentPart)
 }
 
-func (builder *responseBuilder) appendWithQuery(contentPart string, query string, data trainingData) {
+func (builder *responseBuilder) appendWithQuery(contentPart string, query string, label string) {
+	builder.saves = append(builder.saves, responseSave{
+		query:  query,
+		label:  label,
+		offset: builder.sb.Len(),
+	})
+
+	builder.append(contentPart)
+}
+
+func (builder *responseBuilder) appendWithLookup(contentPart string, lookup string) {
 	builder.append(contentPart)
-	builder.storeQueries[query] = data
+	builder.lookups = append(builder.lookups, lookup)
 }
 
 func (builder *responseBuilder) finish() *responseContent {
@@ -46,44 +63,143 @@ func (builder *responseBuilder) finish() *responseContent {
 	response.content = builder.sb.String()
 	builder.sb.Reset()
 
-	response.storeQueries = builder.storeQueries
-	for k := range builder.storeQueries {
-		delete(builder.storeQueries, k)
-	}
+	response.saves = builder.saves
+	builder.saves = nil
+
+	response.lookups = builder.lookups
+	builder.lookups = nil
 
 	return &response
 }
 
-func splitResponseContents(responseContent string, partMaxLength int, splitRune rune) []string {
-	var responseContentParts []string
-	response := []rune(responseContent)
-
-	for len(response) > 0 {
-		end := partMaxLength - 1
+type htmlToken struct {
+	raw string
+	// Length of visible text in UTF-16 code units as Telegram counts it, zero for tags
+	width   int
+	tag     string
+	closing bool
+}
 
-		if len(response) < partMaxLength {
-			end = len(response) - 1
+func tokenizeHTMLContent(content string) []htmlToken {
+	var tokens []htmlToken
+
+	for len(content) > 0 {
+		var token htmlToken
+
+		switch {
+		case content[0] == '<' && strings.IndexByte(content, '>') > 0:
+			token.raw = content[:strings.IndexByte(content, '>')+1]
+			token.closing = strings.HasPrefix(token.raw, "</")
+			if match := htmlTagNamePattern.FindStringSubmatch(token.raw[1:]); match != nil {
+				token.tag = match[1]
+			}
+		case content[0] == '&' && htmlEntityPattern.MatchString(content):
+			token.raw = htmlEntityPattern.FindString(content)
+			token.width = 1
+		default:
+			r, size := utf8.DecodeRuneInString(content)
+			token.raw = content[:size]
+			token.width = len(utf16.Encode([]rune{r}))
 		}
 
-		contentPart := response[:end+1]
-		partEnd := findLastRune(contentPart, splitRune)
-		if partEnd < 0 {
-			partEnd = len(contentPart) - 1
-		}
+		tokens = append(tokens, token)
+		content = content[len(token.raw):]
+	}
+
+	return tokens
+}
 
-		responseContentParts = append(responseContentParts, string(contentPart[:partEnd+1]))
-		response = response[partEnd+1:]
+// Breaks between entries are preferred over breaks between senses or lines,
+// which are preferred over breaks between words
+func htmlBreakPriority(tokens []htmlToken, end int) int {
+	switch {
+	case end == 0 || tokens[end-1].raw != "\n" && tokens[end-1].raw != " ":
+		return 0
+	case tokens[end-1].raw == " ":
+		return 1
+	case end > 1 && tokens[end-2].raw == "\n":
+		return 3
+	default:
+		return 2
 	}
+}
 
-	return responseContentParts
+type htmlBreak struct {
+	end      int
+	width    int
+	openTags []htmlToken
 }
 
-func findLastRune(arr []rune, item rune) int {
-	for i := len(arr) - 1; i >= 0; i-- {
-		if arr[i] == item {
-			return i
+// splitResponseContents splits HTML content into parts with up to partMaxLength
+// visible characters each. Tags open at the end of a part are closed there and
+// reopened at the beginning of the next one
+func splitResponseContents(responseContent string, partMaxLength int) []responseContentPart {
+	var responseContentParts []responseContentPart
+
+	tokens := tokenizeHTMLContent(responseContent)
+	var openTags []htmlToken
+	offset := 0
+
+	for start := 0; start < len(tokens); {
+		var sb strings.Builder
+		for _, tag := range openTags {
+			sb.WriteString(tag.raw)
+		}
+
+		stack := append([]htmlToken{}, openTags...)
+		breaks := map[int]htmlBreak{}
+		var last htmlBreak
+		width := 0
+		end := start
+
+		for end < len(tokens) && (width+tokens[end].width <= partMaxLength || end == start) {
+			token := tokens[end]
+			if token.tag != "" && token.closing {
+				if len(stack) > 0 && stack[len(stack)-1].tag == token.tag {
+					stack = stack[:len(stack)-1]
+				}
+			} else if token.tag != "" {
+				stack = append(stack, token)
+			}
+
+			width += token.width
+			end++
+
+			last = htmlBreak{
+				end:      end,
+				width:    width,
+				openTags: append([]htmlToken{}, stack...),
+			}
+			breaks[htmlBreakPriority(tokens, end)] = last
+		}
+
+		chosen := last
+		if end < len(tokens) {
+			// Prefer the most significant break unless it makes the part too short
+			for priority := 3; priority > 0; priority-- {
+				if candidate, ok := breaks[priority]; ok && candidate.width >= partMaxLength/2 {
+					chosen = candidate
+					break
+				}
+			}
+		}
+
+		for _, token := range tokens[start:chosen.end] {
+			sb.WriteString(token.raw)
+			offset += len(token.raw)
 		}
+
+		for idx := len(chosen.openTags) - 1; idx >= 0; idx-- {
+			sb.WriteString(fmt.Sprintf("</%s>", chosen.openTags[idx].tag))
+		}
+
+		responseContentParts = append(responseContentParts, responseContentPart{
+			content: sb.String(),
+			end:     offset,
+		})
+		openTags = chosen.openTags
+		start = chosen.end
 	}
 
-	return -1
+	return responseContentParts
 }
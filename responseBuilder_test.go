@@ -0,0 +1,93 @@
+package main
+
+import (
+	"html"
+	"reflect"
+	"regexp"
+	"testing"
+	"unicode/utf16"
+)
+
+var testHTMLTagPattern = regexp.MustCompile(`<[^>]*>`)
+
+// visibleWidth counts characters of the HTML as Telegram does, in UTF-16 code units
+func visibleWidth(content string) int {
+	text := html.UnescapeString(testHTMLTagPattern.ReplaceAllString(content, ""))
+	return len(utf16.Encode([]rune(text)))
+}
+
+func TestSplitResponseContents(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		maxLength int
+		want      []string
+	}{
+		{"fits", "<b>word</b> test", 100, []string{"<b>word</b> test"}},
+		{"empty", "", 10, nil},
+		{"word break", "aaaa bbbb cccc", 10, []string{"aaaa bbbb ", "cccc"}},
+		{"line break over word break", "aaa bbb\nccc ddd eee", 12, []string{"aaa bbb\n", "ccc ddd eee"}},
+		{"paragraph break over line break", "aaa bbb\nccc ddd\n\neee fff", 17, []string{"aaa bbb\nccc ddd\n\n", "eee fff"}},
+		{"short break is not preferred", "a\nbbbbbbbbbbbb", 10, []string{"a\nbbbbbbbb", "bbbb"}},
+		{"tag reopened", "<b>aaaa bbbb cccc</b>", 10, []string{"<b>aaaa bbbb </b>", "<b>cccc</b>"}},
+		{"nested tags reopened", "<b><i>aaaa bbbb</i></b>", 5, []string{"<b><i>aaaa </i></b>", "<b><i>bbbb</i></b>"}},
+		{"tag with attributes reopened", `<a href="x">aaaa bbbb</a>`, 5, []string{`<a href="x">aaaa </a>`, `<a href="x">bbbb</a>`}},
+		{"closed tag not reopened", "<b>aa</b> bbbb cccc", 8, []string{"<b>aa</b> bbbb ", "cccc"}},
+		{"surrogate pairs", "😀😀😀", 4, []string{"😀😀", "😀"}},
+		{"surrogate pair not split", "a😀b", 2, []string{"a", "😀", "b"}},
+		{"entity is a character", "&lt;&lt;&lt;", 2, []string{"&lt;&lt;", "&lt;"}},
+		{"cyrillic", "тест тест", 5, []string{"тест ", "тест"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parts := splitResponseContents(test.content, test.maxLength)
+
+			var got []string
+			for _, part := range parts {
+				got = append(got, part.content)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("splitResponseContents(%q, %d) = %q, want %q", test.content, test.maxLength, got, test.want)
+			}
+
+			for idx, part := range parts {
+				if err := validateTelegramHTML(part.content); err != nil {
+					t.Errorf("part %d %q is not valid HTML. %s", idx, part.content, err)
+				}
+
+				if width := visibleWidth(part.content); width > test.maxLength {
+					t.Errorf("part %d %q has %d characters, more than %d", idx, part.content, width, test.maxLength)
+				}
+			}
+
+			if len(parts) > 0 && parts[len(parts)-1].end != len(test.content) {
+				t.Errorf("last part ends at %d, want %d", parts[len(parts)-1].end, len(test.content))
+			}
+		})
+	}
+}
+
+func TestResponseBuilderSaveOffsets(t *testing.T) {
+	builder := newResponseBuilder()
+	builder.append("<b>test</b>\n")
+	builder.appendWithQuery("first\n", "/std_1", "1")
+	builder.appendWithQuery("second\n", "/std_2", "2")
+	response := builder.finish()
+
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"/std_1", "first\n"},
+		{"/std_2", "second\n"},
+	}
+
+	for idx, test := range tests {
+		save := response.saves[idx]
+		if save.query != test.query || response.content[save.offset:save.offset+len(test.want)] != test.want {
+			t.Errorf("save %d is %q at %d, want %q before %q", idx, save.query, save.offset, test.query, test.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	saveTokenSeparator       = "."
+	saveTokenSignatureLength = 8
+)
+
+var (
+	errInvalidSaveToken = errors.New("save token is invalid")
+
+	saveTokenSecret []byte
+)
+
+// Short provider keys keep tokens within callback data limits. Keys are part
+// of already sent tokens, so they must never be changed or reused
+var saveTokenProviderKeys = map[string]string{
+	mWProviderName:          "mw",
+	mWLearnersProviderName:  "ml",
+	mWThesaurusProviderName: "mt",
+	linguaRobotProviderName: "lr",
+	offlineProviderName:     "of",
+	wiktionaryProviderName:  "wk",
+}
+
+// saveToken addresses a sense of a dictionary entry, so the sense can be
+// looked up again and stored whenever the token comes back
+type saveToken struct {
+	provider   string
+	entryID    string
+	senseIndex int
+}
+
+func initSaveTokenSecret(botToken string) {
+	secret := os.Getenv("SAVE_TOKEN_SECRET")
+	if secret == "" {
+		log.Print("Environment variable for save token secret is not set, deriving it from Telegram API token")
+		secret = "save-token:" + botToken
+	}
+
+	saveTokenSecret = []byte(secret)
+}
+
+func signSaveTokenPayload(payload string) string {
+	mac := hmac.New(sha256.New, saveTokenSecret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:saveTokenSignatureLength])
+}
+
+// encode makes a "/std_<provider>.<sense>.<signature>.<entry ID>" command.
+// Entry ID goes last as it is the only part which may contain separators
+func (token saveToken) encode() (string, error) {
+	providerKey, ok := saveTokenProviderKeys[token.provider]
+	if !ok {
+		return "", fmt.Errorf("no save token key for provider '%s'", token.provider)
+	}
+
+	payload := strings.Join([]string{providerKey, strconv.Itoa(token.senseIndex), token.entryID}, saveTokenSeparator)
+	signature := signSaveTokenPayload(payload)
+
+	encoded := fmt.Sprintf("%s_%s%s%d%s%s%s%s",
+		StoreTrainingDataPrefix,
+		providerKey, saveTokenSeparator,
+		token.senseIndex, saveTokenSeparator,
+		signature, saveTokenSeparator,
+		token.entryID)
+	if len(encoded) > maxCallbackDataLength {
+		return "", fmt.Errorf("save token for '%s' entry is too long", token.entryID)
+	}
+
+	return encoded, nil
+}
+
+func decodeSaveToken(encoded string) (*saveToken, error) {
+	if !strings.HasPrefix(encoded, StoreTrainingDataPrefix+"_") {
+		return nil, errInvalidSaveToken
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(encoded, StoreTrainingDataPrefix+"_"), saveTokenSeparator, 4)
+	if len(parts) != 4 || parts[3] == "" {
+		return nil, errInvalidSaveToken
+	}
+
+	providerKey, senseIndex, signature, entryID := parts[0], parts[1], parts[2], parts[3]
+
+	payload := strings.Join([]string{providerKey, senseIndex, entryID}, saveTokenSeparator)
+	if !hmac.Equal([]byte(signature), []byte(signSaveTokenPayload(payload))) {
+		return nil, errInvalidSaveToken
+	}
+
+	var token saveToken
+	for provider, key := range saveTokenProviderKeys {
+		if key == providerKey {
+			token.provider = provider
+		}
+	}
+
+	if token.provider == "" {
+		return nil, errInvalidSaveToken
+	}
+
+	var err error
+	token.senseIndex, err = strconv.Atoi(senseIndex)
+	if err != nil || token.senseIndex < 0 {
+		return nil, errInvalidSaveToken
+	}
+
+	token.entryID = entryID
+	return &token, nil
+}
+
+// entryIDItem drops the homograph number following the last ":" of the entry
+// ID (e.g. "run:1"). Headwords may contain colons themselves
+func entryIDItem(entryID string) string {
+	idx := strings.LastIndex(entryID, ":")
+	if idx <= 0 || idx == len(entryID)-1 {
+		return entryID
+	}
+
+	for _, r := range entryID[idx+1:] {
+		if r < '0' || r > '9' {
+			return entryID
+		}
+	}
+
+	return entryID[:idx]
+}
+
+// trainingDataFromSaveToken looks the entry up again and picks the addressed sense
+func trainingDataFromSaveToken(ctx context.Context, encoded string) (*trainingData, error) {
+	token, err := decodeSaveToken(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := providers.lookupEntry(ctx, token.provider, token.entryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return trainingDataFromEntry(entry, token.senseIndex)
+}
+
+// trainingDataFromEntry picks the sense counting senses of all lexemes. Syllable
+// dots of MW headwords are for display only, so the word is stored without them
+func trainingDataFromEntry(entry *dictionaryEntry, senseIndex int) (*trainingData, error) {
+	lexemeStart := 0
+	for _, lexeme := range entry.lexemes {
+		if senseIndex >= lexemeStart+len(lexeme.senses) {
+			lexemeStart += len(lexeme.senses)
+			continue
+		}
+
+		lemma := lexeme.lemma
+		if lemma == "" {
+			lemma = entry.item
+		}
+
+		// Schedule is started by the scheduler once the data is stored
+		return &trainingData{
+			Item:     strings.ReplaceAll(lemma, "·", ""),
+			ItemData: lexeme.senses[senseIndex-lexemeStart].data,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("entry '%s' has no sense %d", entry.id, senseIndex)
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func withSaveTokenSecret(t *testing.T, secret string) {
+	previous := saveTokenSecret
+	saveTokenSecret = []byte(secret)
+	t.Cleanup(func() { saveTokenSecret = previous })
+}
+
+func TestSaveTokenRoundTrip(t *testing.T) {
+	withSaveTokenSecret(t, "secret")
+
+	tests := []saveToken{
+		{provider: mWProviderName, entryID: "test:1", senseIndex: 0},
+		{provider: mWLearnersProviderName, entryID: "test", senseIndex: 12},
+		{provider: linguaRobotProviderName, entryID: "a.k.a.", senseIndex: 3},
+		{provider: wiktionaryProviderName, entryID: "тест:2", senseIndex: 1},
+		{provider: offlineProviderName, entryID: "look up", senseIndex: 0},
+		{provider: mWProviderName, entryID: "re:invent:2", senseIndex: 1},
+	}
+
+	for _, token := range tests {
+		t.Run(token.entryID, func(t *testing.T) {
+			encoded, err := token.encode()
+			if err != nil {
+				t.Fatalf("encode() failed. %s", err)
+			}
+
+			if len(encoded) > maxCallbackDataLength {
+				t.Errorf("encoded token %q is longer than %d", encoded, maxCallbackDataLength)
+			}
+
+			decoded, err := decodeSaveToken(encoded)
+			if err != nil {
+				t.Fatalf("decodeSaveToken(%q) failed. %s", encoded, err)
+			}
+
+			if *decoded != token {
+				t.Errorf("decodeSaveToken(%q) = %+v, want %+v", encoded, *decoded, token)
+			}
+		})
+	}
+}
+
+func TestSaveTokenEncodeErrors(t *testing.T) {
+	withSaveTokenSecret(t, "secret")
+
+	tests := []struct {
+		name  string
+		token saveToken
+	}{
+		{"unknown provider", saveToken{provider: "unknown", entryID: "test"}},
+		{"too long", saveToken{provider: mWProviderName, entryID: strings.Repeat("a", maxCallbackDataLength)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if encoded, err := test.token.encode(); err == nil {
+				t.Errorf("encode() = %q, want error", encoded)
+			}
+		})
+	}
+}
+
+func TestDecodeSaveTokenRejectsForgery(t *testing.T) {
+	withSaveTokenSecret(t, "secret")
+
+	valid, err := saveToken{provider: mWProviderName, entryID: "test:1", senseIndex: 2}.encode()
+	if err != nil {
+		t.Fatalf("encode() failed. %s", err)
+	}
+
+	// "/std_mw.2.<signature>.test:1"
+	parts := strings.SplitN(valid, saveTokenSeparator, 4)
+	signature := parts[2]
+
+	withSaveTokenSecret(t, "other secret")
+	otherSecret, err := saveToken{provider: mWProviderName, entryID: "test:1", senseIndex: 2}.encode()
+	if err != nil {
+		t.Fatalf("encode() failed. %s", err)
+	}
+
+	withSaveTokenSecret(t, "secret")
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"other sense", strings.Join([]string{parts[0], "3", signature, parts[3]}, saveTokenSeparator)},
+		{"other entry", strings.Join([]string{parts[0], parts[1], signature, "test:2"}, saveTokenSeparator)},
+		{"other provider", strings.Join([]string{StoreTrainingDataPrefix + "_lr", parts[1], signature, parts[3]}, saveTokenSeparator)},
+		{"other secret", otherSecret},
+		{"no signature", strings.Join([]string{parts[0], parts[1], "", parts[3]}, saveTokenSeparator)},
+		{"truncated entry", valid[:len(valid)-2]},
+		{"truncated signature", strings.Join([]string{parts[0], parts[1], signature[:len(signature)-1], parts[3]}, saveTokenSeparator)},
+		{"no entry", strings.Join(parts[:3], saveTokenSeparator) + saveTokenSeparator},
+		{"missing parts", strings.Join(parts[:3], saveTokenSeparator)},
+		{"other prefix", strings.Replace(valid, StoreTrainingDataPrefix, "/save", 1)},
+		{"empty", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if token, err := decodeSaveToken(test.encoded); !errors.Is(err, errInvalidSaveToken) {
+				t.Errorf("decodeSaveToken(%q) = %+v, %v, want errInvalidSaveToken", test.encoded, token, err)
+			}
+		})
+	}
+}
+
+func TestEntryIDItem(t *testing.T) {
+	tests := []struct {
+		entryID string
+		want    string
+	}{
+		{"test", "test"},
+		{"test:1", "test"},
+		{"тест:12", "тест"},
+		{"re:invent", "re:invent"},
+		{"re:invent:2", "re:invent"},
+		{"test:", "test:"},
+		{":1", ":1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.entryID, func(t *testing.T) {
+			if got := entryIDItem(test.entryID); got != test.want {
+				t.Errorf("entryIDItem(%q) = %q, want %q", test.entryID, got, test.want)
+			}
+		})
+	}
+}
+
+func TestTrainingDataFromEntry(t *testing.T) {
+	entry := &dictionaryEntry{
+		id:   "vocabulary:1",
+		item: "vo·cab·u·lary",
+		lexemes: []entryLexeme{
+			{lemma: "vo·cab·u·lary", senses: []lexemeSense{
+				{data: dictionaryItemData{Definition: "a list of words"}},
+				{data: dictionaryItemData{Definition: "a sum of words"}},
+			}},
+			{lemma: "vo·cab·u·lar·ies", senses: []lexemeSense{
+				{data: dictionaryItemData{Definition: "lists of words"}},
+			}},
+			{senses: []lexemeSense{
+				{data: dictionaryItemData{Definition: "no lemma"}},
+			}},
+		},
+	}
+
+	tests := []struct {
+		senseIndex     int
+		wantItem       string
+		wantDefinition string
+		wantErr        bool
+	}{
+		{0, "vocabulary", "a list of words", false},
+		{1, "vocabulary", "a sum of words", false},
+		{2, "vocabularies", "lists of words", false},
+		{3, "vocabulary", "no lemma", false},
+		{4, "", "", true},
+	}
+
+	for _, test := range tests {
+		data, err := trainingDataFromEntry(entry, test.senseIndex)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("trainingDataFromEntry(%d) = %+v, want error", test.senseIndex, data)
+			}
+
+			continue
+		}
+
+		if err != nil || data.Item != test.wantItem || data.ItemData.Definition != test.wantDefinition {
+			t.Errorf("trainingDataFromEntry(%d) = %+v, %v, want '%s' with '%s'", test.senseIndex, data, err, test.wantItem, test.wantDefinition)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	fixedSchedulerName   = "fixed"
+	sm2SchedulerName     = "sm2"
+	fsrsSchedulerName    = "fsrs"
+	defaultSchedulerName = sm2SchedulerName
+
+	// Card is learned once it is not due for that many days
+	retirementInterval = 180
+
+	sm2InitialEaseFactor = 2.5
+	sm2MinEaseFactor     = 1.3
+)
+
+// scheduler tells when a card has to be reviewed next. Its state is kept in
+// training data, so every card stays with the scheduler it was stored with
+type scheduler interface {
+	name() string
+	// start initializes the state of a new card and returns days till its first review
+	start(data *trainingData, now time.Time) int
+	// review updates the state by the grade and returns days till the next
+	// review. Retired card is learned and not reviewed anymore
+	review(data *trainingData, grade reviewGrade, now time.Time) (days int, retired bool)
+}
+
+// userScheduler is a scheduler with parameters fitted to every user
+type userScheduler interface {
+	forUser(userID int) scheduler
+}
+
+var (
+	schedulers = map[string]scheduler{
+		fixedSchedulerName: fixedScheduler{},
+		sm2SchedulerName:   sm2Scheduler{},
+		fsrsSchedulerName:  fsrsScheduler{weights: defaultFSRSWeights},
+	}
+
+	// Scheduler new cards are stored with
+	newCardScheduler scheduler = schedulers[defaultSchedulerName]
+)
+
+// initScheduler picks the scheduler for new cards from TRAINING_SCHEDULER
+// environment variable
+func initScheduler() {
+	name := os.Getenv("TRAINING_SCHEDULER")
+	if name == "" {
+		return
+	}
+
+	cardScheduler, ok := schedulers[name]
+	if !ok {
+		log.Printf("Ignoring unknown training scheduler '%s', using '%s'", name, newCardScheduler.name())
+		return
+	}
+
+	newCardScheduler = cardScheduler
+}
+
+// schedulerFor returns the scheduler of the user's card. Cards stored before
+// there were schedulers have none and are trained with the fixed one
+func schedulerFor(userID int, data *trainingData) scheduler {
+	cardScheduler, ok := schedulers[data.Scheduler]
+	if !ok {
+		cardScheduler = schedulers[fixedSchedulerName]
+	}
+
+	if perUser, ok := cardScheduler.(userScheduler); ok {
+		return perUser.forUser(userID)
+	}
+
+	return cardScheduler
+}
+
+// startTrainingData assigns the scheduler for new cards and returns days till
+// the first review
+func startTrainingData(userID int, data *trainingData) int {
+	data.Scheduler = newCardScheduler.name()
+	return schedulerFor(userID, data).start(data, time.Now())
+}
+
+// fixedScheduler moves cards along the iterations table
+type fixedScheduler struct{}
+
+func (fixedScheduler) name() string {
+	return fixedSchedulerName
+}
+
+func (fixedScheduler) start(data *trainingData, now time.Time) int {
+	data.Iteration = 1
+	return trainingIterationToDays(data.Iteration)
+}
+
+func (fixedScheduler) review(data *trainingData, grade reviewGrade, now time.Time) (int, bool) {
+	data.Iteration = nextTrainingIteration(data.Iteration, grade)
+	return trainingIterationToDays(data.Iteration), data.Iteration > MaxIteration
+}
+
+// sm2Scheduler is SuperMemo 2 algorithm, the interval grows by the ease
+// factor of the card which in turn depends on the grades
+type sm2Scheduler struct{}
+
+func (sm2Scheduler) name() string {
+	return sm2SchedulerName
+}
+
+func (sm2Scheduler) start(data *trainingData, now time.Time) int {
+	data.EaseFactor = sm2InitialEaseFactor
+	data.Repetitions = 0
+	data.Interval = 1
+	return data.Interval
+}
+
+// sm2Quality maps grades to SM-2 response quality from 0 to 5, anything
+// below 3 is a failed recall
+func sm2Quality(grade reviewGrade) float64 {
+	switch grade {
+	case gradeAgain:
+		return 1
+	case gradeHard:
+		return 3
+	case gradeEasy:
+		return 5
+	default:
+		return 4
+	}
+}
+
+func (sm2Scheduler) review(data *trainingData, grade reviewGrade, now time.Time) (int, bool) {
+	if data.EaseFactor == 0 {
+		data.EaseFactor = sm2InitialEaseFactor
+	}
+
+	quality := sm2Quality(grade)
+	if quality < 3 {
+		data.Repetitions = 0
+		data.Interval = 1
+	} else {
+		switch data.Repetitions {
+		case 0:
+			data.Interval = 1
+		case 1:
+			data.Interval = 6
+		default:
+			data.Interval = int(math.Round(float64(data.Interval) * data.EaseFactor))
+		}
+
+		data.Repetitions++
+	}
+
+	data.EaseFactor += 0.1 - (5-quality)*(0.08+(5-quality)*0.02)
+	if data.EaseFactor < sm2MinEaseFactor {
+		data.EaseFactor = sm2MinEaseFactor
+	}
+
+	data.Iteration++
+	return data.Interval, data.Interval > retirementInterval
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSM2Scheduler(t *testing.T) {
+	tests := []struct {
+		name           string
+		grades         []reviewGrade
+		wantIntervals  []int
+		wantEaseFactor float64
+	}{
+		{"good", []reviewGrade{gradeGood, gradeGood, gradeGood, gradeGood}, []int{1, 6, 15, 38}, 2.5},
+		{"easy grows ease", []reviewGrade{gradeEasy, gradeEasy, gradeEasy}, []int{1, 6, 16}, 2.8},
+		{"hard lowers ease", []reviewGrade{gradeHard, gradeHard, gradeHard}, []int{1, 6, 13}, 2.08},
+		{"again starts over", []reviewGrade{gradeGood, gradeGood, gradeAgain, gradeGood}, []int{1, 6, 1, 1}, 1.96},
+		{"ease is bounded", []reviewGrade{gradeAgain, gradeAgain, gradeAgain, gradeGood, gradeGood, gradeGood}, []int{1, 1, 1, 1, 6, 8}, sm2MinEaseFactor},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var data trainingData
+			scheduler := sm2Scheduler{}
+			if days := scheduler.start(&data, time.Now()); days != 1 {
+				t.Errorf("start() = %d days, want 1", days)
+			}
+
+			var intervals []int
+			for _, grade := range test.grades {
+				days, _ := scheduler.review(&data, grade, time.Now())
+				intervals = append(intervals, days)
+			}
+
+			if !reflect.DeepEqual(intervals, test.wantIntervals) {
+				t.Errorf("intervals = %v, want %v", intervals, test.wantIntervals)
+			}
+
+			if math.Abs(data.EaseFactor-test.wantEaseFactor) > 1e-9 {
+				t.Errorf("ease factor = %v, want %v", data.EaseFactor, test.wantEaseFactor)
+			}
+
+			if data.Iteration != len(test.grades) {
+				t.Errorf("iteration = %d, want %d", data.Iteration, len(test.grades))
+			}
+		})
+	}
+}
+
+func TestSM2SchedulerRetires(t *testing.T) {
+	data := trainingData{trainingSchedule: trainingSchedule{EaseFactor: 2.5, Repetitions: 5, Interval: 100}}
+	days, retired := sm2Scheduler{}.review(&data, gradeGood, time.Now())
+	if days != 250 || !retired {
+		t.Errorf("review() = %d, %v, want 250, true", days, retired)
+	}
+}
+
+func TestFixedScheduler(t *testing.T) {
+	tests := []struct {
+		name          string
+		iteration     int
+		grade         reviewGrade
+		wantIteration int
+		wantDays      int
+		wantRetired   bool
+	}{
+		{"again", 5, gradeAgain, 1, 1, false},
+		{"hard", 3, gradeHard, 3, 3, false},
+		{"good", 3, gradeGood, 4, 5, false},
+		{"easy", 3, gradeEasy, 5, 8, false},
+		{"good of last", MaxIteration, gradeGood, MaxIteration + 1, 21, true},
+		{"easy before last", MaxIteration - 1, gradeEasy, MaxIteration + 1, 21, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := trainingData{trainingSchedule: trainingSchedule{Iteration: test.iteration}}
+			days, retired := fixedScheduler{}.review(&data, test.grade, time.Now())
+			if data.Iteration != test.wantIteration || days != test.wantDays || retired != test.wantRetired {
+				t.Errorf("review() = iteration %d, %d days, retired %v, want iteration %d, %d days, retired %v",
+					data.Iteration, days, retired, test.wantIteration, test.wantDays, test.wantRetired)
+			}
+		})
+	}
+}
+
+func TestSchedulerFor(t *testing.T) {
+	tests := []struct {
+		scheduler string
+		want      string
+	}{
+		{"", fixedSchedulerName},
+		{"unknown", fixedSchedulerName},
+		{fixedSchedulerName, fixedSchedulerName},
+		{sm2SchedulerName, sm2SchedulerName},
+		{fsrsSchedulerName, fsrsSchedulerName},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scheduler, func(t *testing.T) {
+			data := trainingData{Scheduler: test.scheduler}
+			if got := schedulerFor(1, &data).name(); got != test.want {
+				t.Errorf("schedulerFor(%q) = %q, want %q", test.scheduler, got, test.want)
+			}
+		})
+	}
+}
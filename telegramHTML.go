@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"regexp"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
+// Tags supported by Telegram with HTML parse mode
+var telegramHTMLTags = map[string]bool{
+	"b":      true,
+	"strong": true,
+	"i":      true,
+	"em":     true,
+	"u":      true,
+	"ins":    true,
+	"s":      true,
+	"strike": true,
+	"del":    true,
+	"a":      true,
+	"code":   true,
+	"pre":    true,
+}
+
+var (
+	htmlEscaper        = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	htmlEntityPattern  = regexp.MustCompile(`^&(amp|lt|gt|quot|#[0-9]+|#x[0-9a-fA-F]+);`)
+	htmlTagNamePattern = regexp.MustCompile(`^/?([a-z]+)`)
+)
+
+// escapeHTML has to be applied to every untrusted text put into HTML messages
+func escapeHTML(text string) string {
+	return htmlEscaper.Replace(text)
+}
+
+// escapeHTMLAttribute escapes text put into a double quoted attribute value
+func escapeHTMLAttribute(text string) string {
+	return strings.ReplaceAll(escapeHTML(text), "\"", "&quot;")
+}
+
+// validateTelegramHTML checks content consists of supported and balanced tags,
+// escaped text and known entities only, so Telegram won't reject it
+func validateTelegramHTML(content string) error {
+	var openTags []string
+
+	for idx := 0; idx < len(content); idx++ {
+		switch content[idx] {
+		case '&':
+			if !htmlEntityPattern.MatchString(content[idx:]) {
+				return fmt.Errorf("unescaped '&' at %d", idx)
+			}
+		case '>':
+			return fmt.Errorf("unescaped '>' at %d", idx)
+		case '<':
+			end := strings.IndexByte(content[idx:], '>')
+			if end < 0 {
+				return fmt.Errorf("unterminated tag at %d", idx)
+			}
+
+			tag := content[idx+1 : idx+end]
+			match := htmlTagNamePattern.FindStringSubmatch(tag)
+			if match == nil || !telegramHTMLTags[match[1]] {
+				return fmt.Errorf("unsupported tag '<%s>' at %d", tag, idx)
+			}
+
+			name := match[1]
+			if strings.HasPrefix(tag, "/") {
+				if len(openTags) == 0 || openTags[len(openTags)-1] != name {
+					return fmt.Errorf("unbalanced closing tag '<%s>' at %d", tag, idx)
+				}
+
+				openTags = openTags[:len(openTags)-1]
+			} else {
+				openTags = append(openTags, name)
+			}
+
+			idx += end
+		}
+	}
+
+	if len(openTags) > 0 {
+		return fmt.Errorf("unclosed tags %v", openTags)
+	}
+
+	return nil
+}
+
+// stripHTML turns HTML content into plain text
+func stripHTML(content string) string {
+	var sb strings.Builder
+
+	for len(content) > 0 {
+		start := strings.IndexByte(content, '<')
+		if start < 0 {
+			sb.WriteString(content)
+			break
+		}
+
+		sb.WriteString(content[:start])
+		end := strings.IndexByte(content[start:], '>')
+		if end < 0 {
+			sb.WriteString(content[start:])
+			break
+		}
+
+		content = content[start+end+1:]
+	}
+
+	return html.UnescapeString(sb.String())
+}
+
+// sendHTMLMessage sends message text as HTML if it is valid or falls back to
+// plain text otherwise, so a single odd definition won't cost the whole reply
+func sendHTMLMessage(msg tgbotapi.MessageConfig) (tgbotapi.Message, error) {
+	err := validateTelegramHTML(msg.Text)
+	if err == nil {
+		msg.ParseMode = "HTML"
+
+		var sentMsg tgbotapi.Message
+		sentMsg, err = bot.Send(msg)
+		if err == nil {
+			return sentMsg, nil
+		}
+	}
+
+	log.Printf("Sending message as plain text. %s", err)
+
+	msg.ParseMode = ""
+	msg.Text = stripHTML(msg.Text)
+	return bot.Send(msg)
+}
+
+// editHTMLMessage falls back to plain text the same way sendHTMLMessage does
+func editHTMLMessage(edit tgbotapi.EditMessageTextConfig) error {
+	err := validateTelegramHTML(edit.Text)
+	if err == nil {
+		edit.ParseMode = "HTML"
+		if _, err = bot.Send(edit); err == nil {
+			return nil
+		}
+	}
+
+	log.Printf("Editing message as plain text. %s", err)
+
+	edit.ParseMode = ""
+	edit.Text = stripHTML(edit.Text)
+	_, err = bot.Send(edit)
+	return err
+}
@@ -1,9 +1,87 @@
 package main
 
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
+)
+
 const (
 	MaxIteration int = 7
+
+	trainCommand        = "/train"
+	undoCommand         = "/undo"
+	trainCallbackPrefix = "train:"
+	// "train:show:<card ID>" reveals the definition
+	trainShowAction = "show"
+	// "train:grade:<card ID>:<grade>:<reveal Unix time>" reschedules the card
+	trainGradeAction = "grade"
+)
+
+type reviewGrade int
+
+const (
+	// Card is forgotten and has to be learned again
+	gradeAgain reviewGrade = iota + 1
+	gradeHard
+	gradeGood
+	gradeEasy
+)
+
+var (
+	reviewGrades = []reviewGrade{gradeAgain, gradeHard, gradeGood, gradeEasy}
+
+	errReviewNotUndoable = errors.New("review has been logged without card state")
 )
 
+func (grade reviewGrade) String() string {
+	switch grade {
+	case gradeAgain:
+		return "Again"
+	case gradeHard:
+		return "Hard"
+	case gradeGood:
+		return "Good"
+	case gradeEasy:
+		return "Easy"
+	default:
+		return "Unknown"
+	}
+}
+
+// trainingCard is a stored training data row due at the given date
+type trainingCard struct {
+	id   int64
+	due  time.Time
+	data trainingData
+}
+
+// reviewLogEntry records a review with the card state before and after it,
+// so the review can be undone and schedulers can be tuned
+type reviewLogEntry struct {
+	reviewOutcome
+	// Time from revealing the definition till grading, zero if unknown
+	timeTaken     time.Duration
+	dueBefore     time.Time
+	dueAfter      time.Time
+	intervalAfter int
+	before        trainingData
+	after         trainingData
+}
+
+// undoneReview is the card restored to its state before the review
+type undoneReview struct {
+	cardID int64
+	item   string
+	grade  reviewGrade
+}
+
 func trainingIterationToDays(iteration int) int {
 	switch iteration {
 	case 1:
@@ -22,3 +100,234 @@ func trainingIterationToDays(iteration int) int {
 		return 21
 	}
 }
+
+// nextTrainingIteration moves the card along the iterations table depending
+// on how well it was remembered. Forgotten card starts over
+func nextTrainingIteration(iteration int, grade reviewGrade) int {
+	switch grade {
+	case gradeAgain:
+		return 1
+	case gradeHard:
+		return iteration
+	case gradeEasy:
+		return iteration + 2
+	default:
+		return iteration + 1
+	}
+}
+
+// gradeTrainingCard reschedules the card with its scheduler and returns days
+// till the next review and whether the card is retired
+func gradeTrainingCard(userID int, card *trainingCard, grade reviewGrade, now time.Time) (int, bool) {
+	days, retired := schedulerFor(userID, &card.data).review(&card.data, grade, now)
+	card.due = now.AddDate(0, 0, days)
+	return days, retired
+}
+
+func handleTrainCommand(inMessage *tgbotapi.Message) {
+	sendNextTrainingCard(inMessage.Chat.ID, inMessage.From.ID)
+}
+
+// sendNextTrainingCard sends the word of the next due card with a button to
+// reveal its definition, or tells when the next card is due
+func sendNextTrainingCard(chatID int64, userID int) {
+	now := time.Now()
+	card, err := getNextTrainingCard(db, userID, now)
+	if err != nil {
+		sendTrainingMessage(chatID, "Failed getting cards to train ... 🤔", nil)
+		return
+	}
+
+	if card == nil {
+		sendTrainingMessage(chatID, nothingToTrainMessage(userID, now), nil)
+		return
+	}
+
+	dueCount, err := countDueTrainingCards(db, userID, now)
+	if err != nil {
+		dueCount = 1
+	}
+
+	text := fmt.Sprintf("🧠 <b>%s</b>\n\n<i>%d to review</i>", escapeHTML(card.data.Item), dueCount)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Show definition 👀", fmt.Sprintf("%s%s:%d", trainCallbackPrefix, trainShowAction, card.id))))
+	sendTrainingMessage(chatID, text, &keyboard)
+}
+
+func nothingToTrainMessage(userID int, now time.Time) string {
+	nextDate, err := getNextTrainingDate(db, userID)
+	if err != nil || nextDate == nil {
+		return "Nothing to train yet ... Save some definitions first! 📚"
+	}
+
+	days := int(math.Ceil(nextDate.Sub(now).Hours() / 24))
+	return fmt.Sprintf("All done for now 🎉 Next review is due in %s", formatDays(days))
+}
+
+func formatDays(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+
+	return fmt.Sprintf("%d days", days)
+}
+
+func sendTrainingMessage(chatID int64, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	if keyboard != nil {
+		msg.ReplyMarkup = *keyboard
+	}
+
+	if _, err := sendHTMLMessage(msg); err != nil {
+		log.Printf("Failed sending training message. %s", err)
+	}
+}
+
+func formatTrainingCard(card *trainingCard) string {
+	return fmt.Sprintf("🧠 <b>%s</b>\n\n%s", escapeHTML(card.data.Item), formatSense(lexemeSense{data: card.data.ItemData}))
+}
+
+func handleTrainCallback(query *tgbotapi.CallbackQuery) {
+	parts := strings.Split(strings.TrimPrefix(query.Data, trainCallbackPrefix), ":")
+	if len(parts) < 2 {
+		answerCallbackQuery(query, "")
+		log.Printf("Invalid training callback query data '%s'", query.Data)
+		return
+	}
+
+	cardID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		answerCallbackQuery(query, "")
+		log.Printf("Invalid training card ID in callback query data '%s'", query.Data)
+		return
+	}
+
+	switch {
+	case parts[0] == trainShowAction:
+		handleTrainShowCallback(query, cardID)
+	case parts[0] == trainGradeAction && (len(parts) == 3 || len(parts) == 4):
+		grade, err := strconv.Atoi(parts[2])
+		if err != nil || grade < int(gradeAgain) || grade > int(gradeEasy) {
+			answerCallbackQuery(query, "")
+			log.Printf("Invalid training grade in callback query data '%s'", query.Data)
+			return
+		}
+
+		// Buttons sent before the reveal time was kept have none
+		var revealedAt time.Time
+		if len(parts) == 4 {
+			if seconds, err := strconv.ParseInt(parts[3], 10, 64); err == nil {
+				revealedAt = time.Unix(seconds, 0)
+			}
+		}
+
+		handleTrainGradeCallback(query, cardID, reviewGrade(grade), revealedAt)
+	default:
+		answerCallbackQuery(query, "")
+		log.Printf("Unknown training callback query data '%s'", query.Data)
+	}
+}
+
+// handleTrainShowCallback reveals the definition and offers grades, which
+// carry the reveal time to measure how long the user thought about the grade
+func handleTrainShowCallback(query *tgbotapi.CallbackQuery, cardID int64) {
+	card, err := getTrainingCard(db, query.From.ID, cardID)
+	if err != nil {
+		answerCallbackQuery(query, "Failed getting the card ... 🤔")
+		return
+	} else if card == nil {
+		answerCallbackQuery(query, "This card is not trained anymore 🤷")
+		return
+	}
+
+	answerCallbackQuery(query, "")
+
+	revealedAt := time.Now().Unix()
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, grade := range reviewGrades {
+		data := fmt.Sprintf("%s%s:%d:%d:%d", trainCallbackPrefix, trainGradeAction, card.id, grade, revealedAt)
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(grade.String(), data))
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(row)
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, formatTrainingCard(card))
+	edit.ReplyMarkup = &keyboard
+	if err := editHTMLMessage(edit); err != nil {
+		log.Printf("Failed revealing training card %d. %s", card.id, err)
+	}
+}
+
+// handleTrainGradeCallback reschedules the card and moves on to the next one
+func handleTrainGradeCallback(query *tgbotapi.CallbackQuery, cardID int64, grade reviewGrade, revealedAt time.Time) {
+	now := time.Now()
+	card, err := getTrainingCard(db, query.From.ID, cardID)
+	if err != nil {
+		answerCallbackQuery(query, "Failed getting the card ... 🤔")
+		return
+	} else if card == nil {
+		answerCallbackQuery(query, "This card is not trained anymore 🤷")
+		return
+	}
+
+	entry := reviewLogEntry{
+		reviewOutcome: reviewOutcome{cardID: card.id, reviewedAt: now, grade: grade},
+		dueBefore:     card.due,
+		before:        card.data,
+	}
+
+	if !revealedAt.IsZero() && now.After(revealedAt) {
+		entry.timeTaken = now.Sub(revealedAt)
+	}
+
+	// Card which is not due anymore has been graded already
+	days, retired := gradeTrainingCard(query.From.ID, card, grade, now)
+	updated, err := rescheduleTrainingCard(db, query.From.ID, card, retired, now)
+	if err != nil {
+		answerCallbackQuery(query, "Failed grading the card ... 🤔")
+		return
+	} else if !updated {
+		answerCallbackQuery(query, "Already graded ✅")
+		return
+	}
+
+	entry.dueAfter = card.due
+	entry.intervalAfter = days
+	entry.after = card.data
+
+	// Failing to log is not a reason to fail the review
+	_ = storeReviewLogEntry(db, query.From.ID, &entry)
+
+	result := fmt.Sprintf("%s, next review in %s", grade, formatDays(days))
+	if retired {
+		result = fmt.Sprintf("%s, the word is learned 🎓", grade)
+	}
+
+	answerCallbackQuery(query, result)
+
+	edit := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID,
+		fmt.Sprintf("%s\n\n<i>%s</i>", formatTrainingCard(card), escapeHTML(result)))
+	if err := editHTMLMessage(edit); err != nil {
+		log.Printf("Failed updating graded training card %d. %s", card.id, err)
+	}
+
+	sendNextTrainingCard(query.Message.Chat.ID, query.From.ID)
+}
+
+// handleUndoCommand reverts the last review and offers the card again
+func handleUndoCommand(inMessage *tgbotapi.Message) {
+	undone, err := undoLastReview(db, inMessage.From.ID)
+	if errors.Is(err, errReviewNotUndoable) {
+		sendSimpleReply(inMessage, "The last review cannot be undone ... 😞")
+		return
+	} else if err != nil {
+		handleErrorWithReply(inMessage, err)
+		return
+	} else if undone == nil {
+		sendSimpleReply(inMessage, "Nothing to undo ... 🤷")
+		return
+	}
+
+	sendSimpleReply(inMessage, fmt.Sprintf("Undone '%s' graded as %s ↩️", undone.item, undone.grade))
+	sendNextTrainingCard(inMessage.Chat.ID, inMessage.From.ID)
+}
@@ -1,10 +1,6 @@
 package main
 
-import (
-	"fmt"
-	"io/ioutil"
-	"net/http"
-)
+import "time"
 
 const (
 	maxContentLength        = 4096
@@ -12,13 +8,28 @@ const (
 )
 
 type dictionaryResponse struct {
-	entries []dictionaryEntry
+	// Name of the provider the entries come from
+	provider string
+	entries  []dictionaryEntry
+	// Words to offer instead when the request has no entries
+	suggestions []string
 }
 
 type dictionaryEntry struct {
+	// Provider specific ID which is enough to look the entry up again
+	id             string
 	item           string
 	pronunciations []entryPronunciation
 	lexemes        []entryLexeme
+	// Optional sections shown after the senses (e.g. etymology)
+	sections []entrySection
+}
+
+type entrySection struct {
+	title string
+	// Text and examples are kept as Telegram HTML
+	text     string
+	examples []string
 }
 
 type entryPronunciation struct {
@@ -28,38 +39,90 @@ type entryPronunciation struct {
 }
 
 type entryLexeme struct {
-	lemma        string
-	partOfSpeech string
-	definitions  []dictionaryItemData
+	lemma           string
+	partOfSpeech    string
+	grammaticalNote string
+	labels          []string
+	inflections     []lexemeInflection
+	// References to other words this lexeme is a form of (e.g. "past tense of run")
+	crossReferences []lexemeCrossReference
+	// Translations not tied to a particular sense, a line per language (e.g. "ru: тест")
+	translations []string
+	senses       []lexemeSense
+	// Run-ons are phrases or derived forms listed under the parent entry
+	runOn          bool
+	pronunciations []entryPronunciation
+}
+
+type lexemeInflection struct {
+	// Optional label shown in italics before the form (e.g. "plural")
+	label string
+	form  string
+}
+
+type lexemeCrossReference struct {
+	label   string
+	targets []lexemeCrossReferenceTarget
+}
+
+type lexemeCrossReferenceTarget struct {
+	word string
+	// Sense of the target the reference points to (e.g. "2"), if any
+	senseNumber string
 }
 
+type senseKind int
+
+const (
+	senseKindRegular senseKind = iota
+	// General sense shared by the subsenses following it
+	senseKindGeneral
+	senseKindSubsense
+	// Continuation of the previous sense introduced by its label (e.g. "also")
+	senseKindDivided
+)
+
+type lexemeSense struct {
+	kind senseKind
+	// Section label shown before the sense (e.g. "transitive verb")
+	divider       string
+	dividerLabels []string
+	number        string
+	label         string
+	// Status labels (e.g. "archaic", "chiefly British")
+	labels          []string
+	grammaticalNote string
+	data            dictionaryItemData
+}
+
+// Definition and examples are kept as Telegram HTML
 type dictionaryItemData struct {
 	Definition string   `json:"definition"`
 	Examples   []string `json:"examples"`
 	Synonyms   []string `json:"synonyms"`
 	Antonyms   []string `json:"antonyms"`
+	// A line per language (e.g. "ru: тест, испытание")
+	Translations []string `json:"translations,omitempty"`
 }
 
 type trainingData struct {
-	ItemData  dictionaryItemData `json:"data"`
-	Item      string             `json:"item"`
-	Iteration int                `json:"iteration"`
+	ItemData dictionaryItemData `json:"data"`
+	Item     string             `json:"item"`
+	// Cards stored before schedulers were added have no scheduler
+	Scheduler string `json:"scheduler,omitempty"`
+	trainingSchedule
 }
 
-func processRequest(request *http.Request) ([]byte, error) {
-	response, err := http.DefaultClient.Do(request)
-	if err != nil {
-		fmt.Printf("Failed processing HTTP request")
-		return nil, err
-	}
-
-	defer response.Body.Close()
-
-	contents, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		fmt.Printf("Failed processing response body")
-		return nil, err
-	}
-
-	return contents, nil
+// trainingSchedule is the scheduler state of a card
+type trainingSchedule struct {
+	Iteration int `json:"iteration"`
+	// SM-2 state, interval is in days
+	EaseFactor  float64 `json:"ease,omitempty"`
+	Interval    int     `json:"interval,omitempty"`
+	Repetitions int     `json:"repetitions,omitempty"`
+	// FSRS state, retrievability is the recall probability at the last review
+	Difficulty     float64    `json:"difficulty,omitempty"`
+	Stability      float64    `json:"stability,omitempty"`
+	Retrievability float64    `json:"retrievability,omitempty"`
+	Reviewed       *time.Time `json:"reviewed,omitempty"`
 }
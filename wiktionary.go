@@ -0,0 +1,716 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	wiktionaryProviderName   = "wiktionary"
+	wiktionaryIndexMagic     = "WKIDX1\n"
+	wiktionaryIndexExtension = ".idx"
+	// Key offset, key length, line offset and line length
+	wiktionaryIndexRecordSize   = 4 + 2 + 8 + 4
+	defaultWiktionaryLanguages  = "en"
+	defaultWiktionaryTranslated = "ru"
+	maxWiktionaryInflections    = 8
+)
+
+// Parts of speech of kaikki dumps which are abbreviated, so they match the
+// ones of other dictionaries
+var wiktionaryPartsOfSpeech = map[string]string{
+	"adj":  "adjective",
+	"adv":  "adverb",
+	"conj": "conjunction",
+	"det":  "determiner",
+	"intj": "interjection",
+	"name": "proper noun",
+	"num":  "numeral",
+	"prep": "preposition",
+	"pron": "pronoun",
+}
+
+// Form tags of kaikki dumps which describe inflection tables, not forms
+var wiktionaryServiceFormTags = map[string]bool{
+	"table-tags":          true,
+	"inflection-template": true,
+	"class":               true,
+	"romanization":        true,
+}
+
+// wiktionaryWord is a line of a Wiktextract dump as published on kaikki.org,
+// each line is a word of a language with a part of speech
+type wiktionaryWord struct {
+	Word          string                  `json:"word"`
+	Language      string                  `json:"lang"`
+	LanguageCode  string                  `json:"lang_code"`
+	PartOfSpeech  string                  `json:"pos"`
+	EtymologyText string                  `json:"etymology_text"`
+	Sounds        []wiktionarySound       `json:"sounds"`
+	Forms         []wiktionaryForm        `json:"forms"`
+	Senses        []wiktionarySense       `json:"senses"`
+	Translations  []wiktionaryTranslation `json:"translations"`
+}
+
+type wiktionarySound struct {
+	IPA    string   `json:"ipa"`
+	Tags   []string `json:"tags"`
+	Mp3Url string   `json:"mp3_url"`
+}
+
+type wiktionaryForm struct {
+	Form string   `json:"form"`
+	Tags []string `json:"tags"`
+}
+
+type wiktionarySense struct {
+	Glosses  []string             `json:"glosses"`
+	Tags     []string             `json:"tags"`
+	Examples []wiktionaryExample  `json:"examples"`
+	Synonyms []wiktionaryLinkWord `json:"synonyms"`
+	Antonyms []wiktionaryLinkWord `json:"antonyms"`
+}
+
+type wiktionaryExample struct {
+	Text string `json:"text"`
+	// Translation of examples in other languages
+	English string `json:"english"`
+}
+
+type wiktionaryLinkWord struct {
+	Word string `json:"word"`
+}
+
+type wiktionaryTranslation struct {
+	LanguageCode string `json:"code"`
+	Word         string `json:"word"`
+	// Gloss of the sense translated
+	Sense string `json:"sense"`
+}
+
+// wiktionaryIndex is a file of fixed size records sorted by lowercase word
+// followed by the words themselves. Lookups binary search the file, so the
+// index is never loaded into memory
+type wiktionaryIndex struct {
+	file  *os.File
+	count int
+}
+
+type wiktionaryIndexRecord struct {
+	key    string
+	offset int64
+	length int
+}
+
+type wiktionaryProvider struct {
+	dump *os.File
+	// Index is nil while it is being built
+	mu    sync.RWMutex
+	index *wiktionaryIndex
+	// Language codes of words looked up, nil means any language
+	languages map[string]bool
+	// Language codes of translations shown in their order
+	translated []string
+}
+
+// newWiktionaryProvider opens WIKTIONARY_DUMP JSONL file and its index next
+// to it. Missing or outdated index is built in the background, the provider
+// is unavailable till then
+func newWiktionaryProvider() (dictionaryProvider, error) {
+	dumpPath := os.Getenv("WIKTIONARY_DUMP")
+	if dumpPath == "" {
+		return nil, errProviderNotConfigured
+	}
+
+	dump, err := os.Open(dumpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &wiktionaryProvider{
+		dump:       dump,
+		translated: splitLanguageCodes(os.Getenv("WIKTIONARY_TRANSLATIONS"), defaultWiktionaryTranslated),
+	}
+
+	// "*" stands for every language of the dump
+	languages := splitLanguageCodes(os.Getenv("WIKTIONARY_LANGUAGES"), defaultWiktionaryLanguages)
+	if len(languages) != 1 || languages[0] != "*" {
+		provider.languages = map[string]bool{}
+		for _, language := range languages {
+			provider.languages[language] = true
+		}
+	}
+
+	indexPath := dumpPath + wiktionaryIndexExtension
+	if !isWiktionaryIndexOutdated(dumpPath, indexPath) {
+		index, err := openWiktionaryIndex(indexPath)
+		if err == nil {
+			provider.setIndex(index)
+			return provider, nil
+		}
+
+		log.Printf("Rebuilding Wiktionary index '%s'. %s", indexPath, err)
+	}
+
+	go provider.buildIndex(dumpPath, indexPath)
+	return provider, nil
+}
+
+func (provider *wiktionaryProvider) buildIndex(dumpPath string, indexPath string) {
+	log.Printf("Building Wiktionary index of '%s'", dumpPath)
+
+	// Separate file, so the build does not move the offset of the dump
+	dump, err := os.Open(dumpPath)
+	if err != nil {
+		log.Printf("Failed building Wiktionary index. %s", err)
+		return
+	}
+
+	defer dump.Close()
+
+	if err = buildWiktionaryIndex(dump, indexPath); err != nil {
+		log.Printf("Failed building Wiktionary index. %s", err)
+		return
+	}
+
+	index, err := openWiktionaryIndex(indexPath)
+	if err != nil {
+		log.Printf("Failed opening built Wiktionary index. %s", err)
+		return
+	}
+
+	provider.setIndex(index)
+}
+
+func (provider *wiktionaryProvider) setIndex(index *wiktionaryIndex) {
+	provider.mu.Lock()
+	provider.index = index
+	provider.mu.Unlock()
+
+	log.Printf("Loaded Wiktionary index with %d words", index.count)
+}
+
+func (provider *wiktionaryProvider) currentIndex() *wiktionaryIndex {
+	provider.mu.RLock()
+	defer provider.mu.RUnlock()
+
+	return provider.index
+}
+
+func splitLanguageCodes(value string, defaultValue string) []string {
+	if strings.TrimSpace(value) == "" {
+		value = defaultValue
+	}
+
+	var codes []string
+	for _, code := range strings.Split(value, ",") {
+		if code = strings.TrimSpace(code); code != "" {
+			codes = append(codes, code)
+		}
+	}
+
+	return codes
+}
+
+func isWiktionaryIndexOutdated(dumpPath string, indexPath string) bool {
+	dumpInfo, err := os.Stat(dumpPath)
+	if err != nil {
+		return true
+	}
+
+	indexInfo, err := os.Stat(indexPath)
+	return err != nil || indexInfo.ModTime().Before(dumpInfo.ModTime())
+}
+
+// buildWiktionaryIndex writes the index to a temporary file first, so an
+// interrupted build never leaves a broken index behind
+func buildWiktionaryIndex(dump *os.File, indexPath string) error {
+	if _, err := dump.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	var records []wiktionaryIndexRecord
+	reader := bufio.NewReader(dump)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var word struct {
+				Word string `json:"word"`
+			}
+
+			if json.Unmarshal(line, &word) == nil && word.Word != "" {
+				records = append(records, wiktionaryIndexRecord{
+					key:    strings.ToLower(word.Word),
+					offset: offset,
+					length: len(line),
+				})
+			}
+
+			offset += int64(len(line))
+		}
+
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// Stable sort keeps words in the dump order, which entry IDs rely on
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].key < records[j].key
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString(wiktionaryIndexMagic)
+	binary.Write(&buf, binary.BigEndian, uint32(len(records)))
+
+	keysOffset := uint32(0)
+	for _, record := range records {
+		binary.Write(&buf, binary.BigEndian, keysOffset)
+		binary.Write(&buf, binary.BigEndian, uint16(len(record.key)))
+		binary.Write(&buf, binary.BigEndian, uint64(record.offset))
+		binary.Write(&buf, binary.BigEndian, uint32(record.length))
+		keysOffset += uint32(len(record.key))
+	}
+
+	for _, record := range records {
+		buf.WriteString(record.key)
+	}
+
+	temporaryPath := indexPath + ".tmp"
+	if err := ioutil.WriteFile(temporaryPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryPath, indexPath)
+}
+
+func openWiktionaryIndex(indexPath string) (*wiktionaryIndex, error) {
+	file, err := os.Open(indexPath)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, len(wiktionaryIndexMagic)+4)
+	if _, err = file.ReadAt(header, 0); err != nil || string(header[:len(wiktionaryIndexMagic)]) != wiktionaryIndexMagic {
+		file.Close()
+		return nil, fmt.Errorf("invalid Wiktionary index '%s'", indexPath)
+	}
+
+	return &wiktionaryIndex{
+		file:  file,
+		count: int(binary.BigEndian.Uint32(header[len(wiktionaryIndexMagic):])),
+	}, nil
+}
+
+func (index *wiktionaryIndex) record(idx int) (wiktionaryIndexRecord, error) {
+	recordsOffset := int64(len(wiktionaryIndexMagic) + 4)
+
+	raw := make([]byte, wiktionaryIndexRecordSize)
+	if _, err := index.file.ReadAt(raw, recordsOffset+int64(idx)*wiktionaryIndexRecordSize); err != nil {
+		return wiktionaryIndexRecord{}, err
+	}
+
+	keyOffset := binary.BigEndian.Uint32(raw[0:4])
+	key := make([]byte, binary.BigEndian.Uint16(raw[4:6]))
+	keysOffset := recordsOffset + int64(index.count)*wiktionaryIndexRecordSize
+	if _, err := index.file.ReadAt(key, keysOffset+int64(keyOffset)); err != nil {
+		return wiktionaryIndexRecord{}, err
+	}
+
+	return wiktionaryIndexRecord{
+		key:    string(key),
+		offset: int64(binary.BigEndian.Uint64(raw[6:14])),
+		length: int(binary.BigEndian.Uint32(raw[14:18])),
+	}, nil
+}
+
+// find returns records of the word in the dump order
+func (index *wiktionaryIndex) find(word string) ([]wiktionaryIndexRecord, error) {
+	key := strings.ToLower(word)
+
+	var searchErr error
+	first := sort.Search(index.count, func(idx int) bool {
+		record, err := index.record(idx)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+
+		return record.key >= key
+	})
+
+	if searchErr != nil {
+		return nil, searchErr
+	}
+
+	var records []wiktionaryIndexRecord
+	for idx := first; idx < index.count; idx++ {
+		record, err := index.record(idx)
+		if err != nil {
+			return nil, err
+		} else if record.key != key {
+			break
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+func (provider *wiktionaryProvider) name() string {
+	return wiktionaryProviderName
+}
+
+func (provider *wiktionaryProvider) isLocal() bool {
+	return true
+}
+
+// fetch makes a JSON array of the dump lines with the word in the configured languages
+func (provider *wiktionaryProvider) fetch(ctx context.Context, item string) ([]byte, error) {
+	index := provider.currentIndex()
+	if index == nil {
+		return nil, fmt.Errorf("%w: Wiktionary index is being built", errProviderUnavailable)
+	}
+
+	records, err := index.find(strings.TrimSpace(item))
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []json.RawMessage
+	for _, record := range records {
+		line := make([]byte, record.length)
+		if _, err = provider.dump.ReadAt(line, record.offset); err != nil {
+			return nil, err
+		}
+
+		var word struct {
+			LanguageCode string `json:"lang_code"`
+		}
+
+		if err = json.Unmarshal(line, &word); err != nil {
+			log.Printf("Skipping malformed Wiktionary line at %d. %s", record.offset, err)
+			continue
+		}
+
+		if provider.languages == nil || provider.languages[word.LanguageCode] {
+			lines = append(lines, json.RawMessage(bytes.TrimSpace(line)))
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, errNothingFound
+	}
+
+	return json.Marshal(lines)
+}
+
+func (provider *wiktionaryProvider) decode(contents []byte) (*dictionaryResponse, error) {
+	var words []wiktionaryWord
+	if err := json.Unmarshal(contents, &words); err != nil {
+		return nil, err
+	}
+
+	response := convertWiktionaryWords(words, provider.translated)
+	if len(response.entries) == 0 {
+		return nil, errNothingFound
+	}
+
+	return response, nil
+}
+
+func convertWiktionaryWords(words []wiktionaryWord, translated []string) *dictionaryResponse {
+	var response dictionaryResponse
+
+	for idx, word := range words {
+		entry := dictionaryEntry{
+			id:             fmt.Sprintf("%s:%d", word.Word, idx+1),
+			item:           word.Word,
+			pronunciations: convertWiktionarySounds(word.Sounds),
+		}
+
+		partOfSpeech := word.PartOfSpeech
+		if fullPartOfSpeech, ok := wiktionaryPartsOfSpeech[partOfSpeech]; ok {
+			partOfSpeech = fullPartOfSpeech
+		}
+
+		lexeme := entryLexeme{
+			lemma:        word.Word,
+			partOfSpeech: partOfSpeech,
+			inflections:  convertWiktionaryForms(word.Word, word.Forms),
+		}
+
+		if word.LanguageCode != "en" && word.Language != "" {
+			lexeme.labels = append(lexeme.labels, word.Language)
+		}
+
+		// Translations are attached to the first sense whose glosses contain
+		// the translated gloss, the others are shown for the whole lexeme
+		senseTranslations := make([]map[string][]string, len(word.Senses))
+		otherTranslations := map[string][]string{}
+		for _, translation := range word.Translations {
+			if translation.Word == "" {
+				continue
+			}
+
+			senseIdx := matchWiktionaryTranslation(word.Senses, translation)
+			if senseIdx < 0 {
+				otherTranslations[translation.LanguageCode] = append(otherTranslations[translation.LanguageCode], translation.Word)
+				continue
+			}
+
+			if senseTranslations[senseIdx] == nil {
+				senseTranslations[senseIdx] = map[string][]string{}
+			}
+
+			senseTranslations[senseIdx][translation.LanguageCode] = append(senseTranslations[senseIdx][translation.LanguageCode], translation.Word)
+		}
+
+		lexeme.translations = formatWiktionaryTranslations(otherTranslations, translated)
+
+		for senseIdx, wSense := range word.Senses {
+			if len(wSense.Glosses) == 0 {
+				continue
+			}
+
+			var itemData dictionaryItemData
+			itemData.Definition = escapeHTML(strings.Join(wSense.Glosses, "; "))
+			itemData.Translations = formatWiktionaryTranslations(senseTranslations[senseIdx], translated)
+
+			for _, example := range wSense.Examples {
+				if example.Text == "" {
+					continue
+				}
+
+				text := escapeHTML(example.Text)
+				if example.English != "" {
+					text = fmt.Sprintf("%s <i>(%s)</i>", text, escapeHTML(example.English))
+				}
+
+				itemData.Examples = append(itemData.Examples, text)
+			}
+
+			for _, synonym := range wSense.Synonyms {
+				itemData.Synonyms = append(itemData.Synonyms, synonym.Word)
+			}
+
+			for _, antonym := range wSense.Antonyms {
+				itemData.Antonyms = append(itemData.Antonyms, antonym.Word)
+			}
+
+			lexeme.senses = append(lexeme.senses, lexemeSense{
+				labels: wSense.Tags,
+				data:   itemData,
+			})
+		}
+
+		if len(lexeme.senses) == 0 {
+			continue
+		}
+
+		entry.lexemes = append(entry.lexemes, lexeme)
+
+		if word.EtymologyText != "" {
+			entry.sections = append(entry.sections, entrySection{
+				title: "Etymology",
+				text:  escapeHTML(word.EtymologyText),
+			})
+		}
+
+		response.entries = append(response.entries, entry)
+	}
+
+	return &response
+}
+
+// enriches adds translations to the responses of any other lookup provider
+func (provider *wiktionaryProvider) enriches(providerName string) bool {
+	return providerName != wiktionaryProviderName && !enrichingOnlyProviders[providerName]
+}
+
+// enrich matches lexemes by lemma and part of speech, then gives the
+// translations of every Wiktionary sense to the sense with the most similar
+// definition. Translations of unmatched senses are shown for the whole lexeme
+func (provider *wiktionaryProvider) enrich(response *dictionaryResponse, enrichment *dictionaryResponse) {
+	for entryIdx := range response.entries {
+		entry := &response.entries[entryIdx]
+		for lexemeIdx := range entry.lexemes {
+			lexeme := &entry.lexemes[lexemeIdx]
+			if lexeme.runOn {
+				continue
+			}
+
+			for _, wiktionaryLexeme := range findMatchingLexemes(enrichment, lexeme) {
+				lexeme.translations = mergeTranslations(lexeme.translations, wiktionaryLexeme.translations)
+
+				for _, wiktionarySense := range wiktionaryLexeme.senses {
+					if len(wiktionarySense.data.Translations) == 0 {
+						continue
+					}
+
+					idx := matchSenseByDefinition(lexeme, wiktionarySense)
+					if idx < 0 {
+						lexeme.translations = mergeTranslations(lexeme.translations, wiktionarySense.data.Translations)
+						continue
+					}
+
+					data := &lexeme.senses[idx].data
+					data.Translations = mergeTranslations(data.Translations, wiktionarySense.data.Translations)
+				}
+			}
+		}
+	}
+}
+
+// mergeTranslations adds words of "<language>: <word>, ..." lines to the lines
+// of the same language and appends lines of other languages
+func mergeTranslations(lines []string, added []string) []string {
+	for _, line := range added {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		merged := false
+		for idx, existing := range lines {
+			existingParts := strings.SplitN(existing, ": ", 2)
+			if len(existingParts) != 2 || existingParts[0] != parts[0] {
+				continue
+			}
+
+			words := strings.Split(existingParts[1], ", ")
+			for _, word := range strings.Split(parts[1], ", ") {
+				found := false
+				for _, existingWord := range words {
+					found = found || existingWord == word
+				}
+
+				if !found {
+					words = append(words, word)
+				}
+			}
+
+			lines[idx] = fmt.Sprintf("%s: %s", parts[0], strings.Join(words, ", "))
+			merged = true
+			break
+		}
+
+		if !merged {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+func matchWiktionaryTranslation(senses []wiktionarySense, translation wiktionaryTranslation) int {
+	gloss := strings.ToLower(strings.TrimSpace(translation.Sense))
+	if gloss == "" {
+		return -1
+	}
+
+	for idx, sense := range senses {
+		for _, senseGloss := range sense.Glosses {
+			if strings.Contains(strings.ToLower(senseGloss), gloss) {
+				return idx
+			}
+		}
+	}
+
+	return -1
+}
+
+// formatWiktionaryTranslations makes a line of unique translations per
+// language in the configured order of languages
+func formatWiktionaryTranslations(translations map[string][]string, translated []string) []string {
+	var lines []string
+
+	for _, code := range translated {
+		var words []string
+		added := map[string]bool{}
+		for _, word := range translations[code] {
+			if !added[word] {
+				added[word] = true
+				words = append(words, word)
+			}
+		}
+
+		if len(words) > 0 {
+			lines = append(lines, fmt.Sprintf("%s: %s", code, strings.Join(words, ", ")))
+		}
+	}
+
+	return lines
+}
+
+func convertWiktionarySounds(sounds []wiktionarySound) []entryPronunciation {
+	var pronunciations []entryPronunciation
+
+	for _, sound := range sounds {
+		if sound.IPA == "" {
+			continue
+		}
+
+		pronunciations = append(pronunciations, entryPronunciation{
+			regions:        sound.Tags,
+			transcriptions: []string{sound.IPA},
+		})
+	}
+
+	// Audio comes in separate sounds, it is attached to the first transcription
+	for _, sound := range sounds {
+		if sound.Mp3Url == "" {
+			continue
+		}
+
+		if len(pronunciations) == 0 {
+			pronunciations = append(pronunciations, entryPronunciation{})
+		}
+
+		pronunciations[0].audioUrl = sound.Mp3Url
+		break
+	}
+
+	return pronunciations
+}
+
+func convertWiktionaryForms(headword string, forms []wiktionaryForm) []lexemeInflection {
+	var inflections []lexemeInflection
+
+	for _, form := range forms {
+		if form.Form == "" || form.Form == headword || len(inflections) == maxWiktionaryInflections {
+			continue
+		}
+
+		isService := false
+		for _, tag := range form.Tags {
+			isService = isService || wiktionaryServiceFormTags[tag]
+		}
+
+		if isService {
+			continue
+		}
+
+		inflections = append(inflections, lexemeInflection{
+			label: strings.Join(form.Tags, " "),
+			form:  form.Form,
+		})
+	}
+
+	return inflections
+}